@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	AggregateMean      = "mean"
+	AggregateMin       = "min"
+	AggregateMax       = "max"
+	AggregateMedian    = "median"
+	AggregateWeighted  = "weighted"
+	AggregateTopBottom = "top-bottom"
+)
+
+// Aggregate computes thermostat control value from several sensors (by name). Failed sensors are
+// skipped, when less than MinSensors (default 1) are ok control value fails (and failsafe follows
+// its AfterFailures / MaxAgeSeconds). Function: mean (default), min, max, median, weighted (with
+// Weights) or top-bottom (difference of first and second sensor, e.g. tank stratification).
+type Aggregate struct {
+	Sensors    []string
+	Function   string
+	Weights    []float64 `json:",omitempty"`
+	MinSensors int       `json:",omitempty"`
+
+	Value float64
+	Used  int
+
+	result *OwSlave
+}
+
+// Init checks config and sets defaults, name is used for aggregated value (thermostat sensor name).
+func (ag *Aggregate) Init(name string) error {
+	ag.result = &OwSlave{Name: name}
+
+	if len(ag.Sensors) == 0 {
+		return fmt.Errorf("Aggregate Init: no Sensors")
+	}
+	switch ag.Function {
+	case "":
+		ag.Function = AggregateMean
+	case AggregateMean, AggregateMin, AggregateMax, AggregateMedian:
+	case AggregateWeighted:
+		if len(ag.Weights) != len(ag.Sensors) {
+			return fmt.Errorf("Aggregate Init: %d Weights for %d Sensors", len(ag.Weights), len(ag.Sensors))
+		}
+		for ix, weight := range ag.Weights {
+			if weight <= 0 {
+				return fmt.Errorf("Aggregate Init: weight %d (%v) should be positive", ix+1, weight)
+			}
+		}
+	case AggregateTopBottom:
+		if len(ag.Sensors) != 2 {
+			return fmt.Errorf("Aggregate Init: top-bottom requires 2 Sensors (top and bottom), got %d", len(ag.Sensors))
+		}
+		ag.MinSensors = 2
+	default:
+		return fmt.Errorf("Aggregate Init: unknown Function (%s)", ag.Function)
+	}
+
+	if ag.MinSensors == 0 {
+		ag.MinSensors = 1
+	}
+	if ag.MinSensors < 0 || ag.MinSensors > len(ag.Sensors) {
+		return fmt.Errorf("Aggregate Init: MinSensors (%d) out of 1-%d range", ag.MinSensors, len(ag.Sensors))
+	}
+
+	return nil
+}
+
+// Update computes aggregated value from current sensor readouts, find returns sensor by name.
+func (ag *Aggregate) Update(find func(name string) *OwSlave) {
+	var values, weights []float64
+	for ix, name := range ag.Sensors {
+		slave := find(name)
+		if slave == nil || !slave.IsOk() {
+			continue
+		}
+		values = append(values, slave.Value)
+		if ag.Function == AggregateWeighted {
+			weights = append(weights, ag.Weights[ix])
+		}
+	}
+	ag.Used = len(values)
+
+	if len(values) < ag.MinSensors {
+		ag.result.Status = SlaveError
+		ag.result.LastError = fmt.Sprintf("%d of %d sensors ok, %d required", len(values), len(ag.Sensors), ag.MinSensors)
+		ag.result.Failures++
+		return
+	}
+
+	ag.Value = aggregate(ag.Function, values, weights)
+	ag.result.setValue(ag.Value)
+}
+
+// aggregate returns value computed with function (values are not empty).
+func aggregate(function string, values []float64, weights []float64) (result float64) {
+	switch function {
+	case AggregateMin:
+		result = values[0]
+		for _, value := range values {
+			if value < result {
+				result = value
+			}
+		}
+	case AggregateMax:
+		result = values[0]
+		for _, value := range values {
+			if value > result {
+				result = value
+			}
+		}
+	case AggregateMedian:
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[middle-1] + sorted[middle]) / 2
+		}
+		return sorted[middle]
+	case AggregateWeighted:
+		var sum float64
+		for ix, value := range values {
+			result += value * weights[ix]
+			sum += weights[ix]
+		}
+		result /= sum
+	case AggregateTopBottom:
+		result = values[0] - values[1]
+	default:
+		for _, value := range values {
+			result += value
+		}
+		result /= float64(len(values))
+	}
+
+	return
+}
+
+// getInput returns sensor used for control: aggregated value or thermostat sensor.
+func (th *Thermo) getInput() *OwSlave {
+	if th.Aggregate != nil {
+		return th.Aggregate.result
+	}
+
+	return th.Sensor
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Boost is a temporary heat up of single thermostat, it ends at Until (if set)
+// or when sensor reaches heat up setpoint (UntilTarget), without both it lasts until cancelled.
+type Boost struct {
+	Until       time.Time `json:",omitempty"`
+	UntilTarget bool      `json:",omitempty"`
+
+	RemainingSeconds float64 `json:",omitempty"`
+}
+
+// getState returns boost as kept in StateFile: without RemainingSeconds (changing every cycle),
+// so running boost doesn't cause state file write on every refresh.
+func (boost *Boost) getState() *Boost {
+	if boost == nil {
+		return nil
+	}
+
+	return &Boost{Until: boost.Until, UntilTarget: boost.UntilTarget}
+}
+
+// updateRemaining sets RemainingSeconds from Until.
+func (boost *Boost) updateRemaining() {
+	if boost != nil && !boost.Until.IsZero() {
+		boost.RemainingSeconds = time.Until(boost.Until).Seconds()
+	}
+}
+
+// StartBoost starts boost for given duration (0 means no time limit) and/or until target is reached.
+func (th *Thermo) StartBoost(duration time.Duration, untilTarget bool) {
+	th.Boost = &Boost{UntilTarget: untilTarget}
+	if duration > 0 {
+		th.Boost.Until = time.Now().Add(duration)
+	}
+	log.Printf("Thermo StartBoost: (%s) boost started (duration: %v, until target: %v)", th.Sensor.Name, duration, th.Boost.UntilTarget)
+
+	th.CheckBoost()
+	th.HeatUpMode = th.HeatUpMode || th.Boost != nil
+}
+
+// CancelBoost ends boost, heat up mode is left to OffPeak/EnergyPanel (set on next cycle).
+func (th *Thermo) CancelBoost() {
+	if th.Boost == nil {
+		return
+	}
+	log.Printf("Thermo CancelBoost: (%s) boost cancelled", th.Sensor.Name)
+	th.Boost = nil
+	th.HeatUpMode = false
+}
+
+// CheckBoost ends expired boost and returns true if boost is still active.
+func (th *Thermo) CheckBoost() bool {
+	if th.Boost == nil {
+		return false
+	}
+
+	if !th.Boost.Until.IsZero() {
+		if !time.Now().Before(th.Boost.Until) {
+			log.Printf("Thermo CheckBoost: (%s) boost time is over", th.Sensor.Name)
+			th.Boost = nil
+			return false
+		}
+		th.Boost.updateRemaining()
+	}
+
+	if th.Boost.UntilTarget && th.getInput().IsOk() && th.isTargetReached(th.getSetpoint(true)) {
+		log.Printf("Thermo CheckBoost: (%s) boost target reached", th.Sensor.Name)
+		th.Boost = nil
+		return false
+	}
+
+	return true
+}
+
+func (th *Thermo) isTargetReached(target float64) bool {
+	if th.Mode == ModeCool {
+		return th.getInput().Value <= target
+	}
+
+	return th.getInput().Value >= target
+}
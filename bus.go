@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BusBackend is a source of 1-wire sensor readouts used by OwSet.
+type BusBackend interface {
+	// Discover returns all temperature sensors currently present on the bus.
+	Discover() ([]BusDevice, error)
+	// Read returns single readout of given sensor, if device Family is empty backend should find it.
+	Read(dev BusDevice) (BusReadout, error)
+}
+
+// BusConverter is implemented by backends able to start conversion on all sensors at once,
+// OwSet calls ConvertAll before reading sensors.
+type BusConverter interface {
+	ConvertAll() error
+}
+
+// ErrBusDeviceMissing is returned (wrapped) by backends when sensor is not present on the bus.
+var ErrBusDeviceMissing = errors.New("device missing")
+
+// BusRawReader is implemented by backends able to return raw device data (used for diagnostics).
+type BusRawReader interface {
+	ReadRaw(dev BusDevice) ([]byte, error)
+}
+
+// BusDevice identifies sensor on the bus: family code (e.g. "28") and 48-bit serial.
+type BusDevice struct {
+	Family string
+	Id     uint64
+}
+
+func (dev BusDevice) String() string {
+	return fmt.Sprintf("%s-%012x", dev.Family, dev.Id)
+}
+
+// BusReadout is a single temperature readout received from the bus, Value in 1/1000 °C.
+type BusReadout struct {
+	Value int64
+	CrcOk bool
+}
+
+// getBusFamilies returns family codes accepted with given prefix (e.g. "28-"), all known families for empty prefix.
+func getBusFamilies(slavePrefix string) (families []string) {
+	prefix := strings.ToLower(strings.TrimSuffix(slavePrefix, "-"))
+	for _, fam := range owFamilies {
+		if len(prefix) == 0 || fam.Code == prefix {
+			families = append(families, fam.Code)
+		}
+	}
+
+	return
+}
+
+// SysfsBus reads sensors exposed by the kernel w1 driver (w1-gpio) in sysfs.
+// Path can point to any directory with the same layout, so it can be used with a fake tree, without a Pi.
+type SysfsBus struct {
+	Path        string
+	SlavePrefix string
+}
+
+func (sb *SysfsBus) Discover() (devices []BusDevice, err error) {
+	devs, err := ioutil.ReadDir(sb.Path)
+	if err != nil {
+		return nil, fmt.Errorf("SysfsBus Discover: error reading dir (%s):\n%w", sb.Path, err)
+	}
+
+	families := getBusFamilies(sb.SlavePrefix)
+	for _, dev := range devs {
+		nameSlice := strings.SplitN(dev.Name(), "-", 2)
+		if len(nameSlice) != 2 || !containsString(families, nameSlice[0]) {
+			continue
+		}
+		id, err := strconv.ParseUint(nameSlice[1], 16, 64)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, BusDevice{Family: nameSlice[0], Id: id})
+	}
+
+	return devices, nil
+}
+
+func (sb *SysfsBus) findFamily(id uint64) string {
+	for _, family := range getBusFamilies(sb.SlavePrefix) {
+		_, err := os.Stat(filepath.Join(sb.Path, BusDevice{Family: family, Id: id}.String()))
+		if err == nil {
+			return family
+		}
+	}
+
+	return ""
+}
+
+func (sb *SysfsBus) Read(dev BusDevice) (readout BusReadout, err error) {
+	if len(dev.Family) == 0 {
+		dev.Family = sb.findFamily(dev.Id)
+		if len(dev.Family) == 0 {
+			return readout, fmt.Errorf("SysfsBus Read: (id %x) %w", dev.Id, ErrBusDeviceMissing)
+		}
+	}
+
+	wslave, err := sb.ReadRaw(dev)
+	if err != nil {
+		return readout, fmt.Errorf("SysfsBus Read:\n%w", err)
+	}
+
+	readout, err = parseW1Slave(dev.Family, wslave)
+	if err != nil {
+		return readout, fmt.Errorf("SysfsBus Read: (%s) parsing w1_slave failed:\n%w", dev, err)
+	}
+
+	return readout, nil
+}
+
+// ReadRaw returns content of device w1_slave file.
+func (sb *SysfsBus) ReadRaw(dev BusDevice) ([]byte, error) {
+	wslave, err := ioutil.ReadFile(filepath.Join(sb.Path, dev.String(), "w1_slave"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("SysfsBus ReadRaw: (%s) %w", dev, ErrBusDeviceMissing)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("SysfsBus ReadRaw: error reading file (%s):\n%w", dev, err)
+	}
+
+	return wslave, nil
+}
+
+func containsString(list []string, item string) bool {
+	for _, elem := range list {
+		if elem == item {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeW1Slave writes w1_slave file of device in fake sysfs tree.
+func writeW1Slave(t *testing.T, root, device, scratchpad, value string) {
+	t.Helper()
+	dir := filepath.Join(root, device)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := scratchpad + " : crc=00 YES\n" + scratchpad + " t=" + value + "\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "w1_slave"), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSysfsBusDiscoverAndRead(t *testing.T) {
+	root := t.TempDir()
+	writeW1Slave(t, root, "28-0316a27955ff", "72 01 4b 46 7f ff 0e 10 57", "23125")
+	writeW1Slave(t, root, "10-000801b5e0a1", "5f ff 4b 46 ff ff 0c 10 1c", "-80375")
+	os.MkdirAll(filepath.Join(root, "w1_bus_master1"), 0755)
+
+	bus := &SysfsBus{Path: root}
+	devices, err := bus.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %v", devices)
+	}
+
+	readout, err := bus.Read(BusDevice{Id: 0x0316a27955ff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readout.Value != 23125 || !readout.CrcOk {
+		t.Errorf("unexpected readout %+v", readout)
+	}
+
+	prefixed := &SysfsBus{Path: root, SlavePrefix: "28-"}
+	devices, _ = prefixed.Discover()
+	if len(devices) != 1 || devices[0].Family != "28" {
+		t.Errorf("expected only 28 family device, got %v", devices)
+	}
+
+	_, err = bus.Read(BusDevice{Family: "28", Id: 0x1234})
+	if !errors.Is(err, ErrBusDeviceMissing) {
+		t.Errorf("expected ErrBusDeviceMissing, got %v", err)
+	}
+}
+
+// TestSysfsCycle runs InitSlaves, RefreshAll and cycle against fake sysfs tree with fake thermostat output.
+func TestSysfsCycle(t *testing.T) {
+	root := t.TempDir()
+	writeW1Slave(t, root, "28-0316a27955ff", "72 01 4b 46 7f ff 0e 10 57", "23125")
+	writeW1Slave(t, root, "28-000000000001", "5e ff 4b 46 7f ff 02 10 00", "-10125")
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+		"Path": "` + root + `",
+		"RefreshSeconds": 5,
+		"Sensors": [{
+			"Name": "tank",
+			"HexId": "28-0316a27955ff",
+			"Thermostat": {"Gpio": 21, "Setpoint": 25, "Output": {"Driver": "fake"}}
+		}]
+	}`
+	err := ioutil.WriteFile(configPath, []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeOutputs()
+
+	wires := &OwSet{}
+	err = wires.Set(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wires.InitSlaves()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wires.Sensors) != 2 {
+		t.Fatalf("expected configured and discovered sensor, got %d sensors", len(wires.Sensors))
+	}
+	tank := wires.GetSlaveByName("tank")
+	if tank.Value != 23.125 || !tank.IsOk() {
+		t.Errorf("tank: unexpected readout %v (%s)", tank.Value, tank.Status)
+	}
+	outdoor := wires.GetSlaveById(1)
+	if outdoor == nil || outdoor.Value != -10.125 {
+		t.Fatalf("discovered sensor not found or wrong value: %+v", outdoor)
+	}
+
+	output, err := getOutput(tank.Thermostat.Output, 21)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wires.cycle()
+	if state, _ := output.Get(); !state || !tank.Thermostat.IsOn {
+		t.Errorf("thermostat should be on below setpoint (output %v)", state)
+	}
+
+	writeW1Slave(t, root, "28-0316a27955ff", "e2 01 4b 46 7f ff 0e 10 00", "30125")
+	wires.cycle()
+	if tank.Value != 30.125 {
+		t.Errorf("tank: expected 30.125 after refresh, got %v", tank.Value)
+	}
+	if state, _ := output.Get(); state || tank.Thermostat.IsOn {
+		t.Errorf("thermostat should be off above setpoint (output %v)", state)
+	}
+
+	os.RemoveAll(filepath.Join(root, "28-000000000001"))
+	err = wires.RefreshAll()
+	if err == nil {
+		t.Error("expected refresh error for removed device")
+	}
+	if outdoor.Status != SlaveMissing || outdoor.Value != -10.125 {
+		t.Errorf("removed device: expected missing with last value kept, got %s %v", outdoor.Status, outdoor.Value)
+	}
+	if !tank.IsOk() {
+		t.Errorf("tank should not be affected by other sensor failure, got %s", tank.Status)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	SmoothingMedian = "median"
+	SmoothingEma    = "ema"
+
+	// powerOnValue is DS18B20 power-on reset value, read when conversion didn't happen (e.g. loose wire).
+	powerOnValue = 85.0
+)
+
+// Calibration corrects and checks sensor readouts. Raw value is corrected by two-point linear
+// calibration (raw readouts RawLow/RawHigh at reference temperatures RefLow/RefHigh) and Offset.
+// Readouts of 85 °C (unless Accept85), outside Min-Max (default -55 to 125) or changing faster
+// than MaxRate °C per minute are rejected. Optional Smoothing: median (of last Samples, default 5)
+// or ema (exponential moving average with Alpha, default 0.3).
+type Calibration struct {
+	Offset  float64 `json:",omitempty"`
+	RawLow  float64 `json:",omitempty"`
+	RefLow  float64 `json:",omitempty"`
+	RawHigh float64 `json:",omitempty"`
+	RefHigh float64 `json:",omitempty"`
+
+	Min      *float64 `json:",omitempty"`
+	Max      *float64 `json:",omitempty"`
+	Accept85 bool     `json:",omitempty"`
+	MaxRate  float64  `json:",omitempty"`
+
+	Smoothing string  `json:",omitempty"`
+	Samples   int     `json:",omitempty"`
+	Alpha     float64 `json:",omitempty"`
+
+	lastValue float64
+	lastTime  time.Time
+	history   []float64
+	smoothed  float64
+	primed    bool
+}
+
+// Init checks calibration config and sets defaults.
+func (cal *Calibration) Init() error {
+	twoPoint := cal.RawLow != 0 || cal.RawHigh != 0 || cal.RefLow != 0 || cal.RefHigh != 0
+	if twoPoint && cal.RawLow == cal.RawHigh {
+		return fmt.Errorf("Calibration Init: RawLow and RawHigh (%v) should differ", cal.RawLow)
+	}
+	min, max := cal.getLimits()
+	if min >= max {
+		return fmt.Errorf("Calibration Init: Min (%v) should be lower than Max (%v)", min, max)
+	}
+	if cal.MaxRate < 0 {
+		return fmt.Errorf("Calibration Init: MaxRate (%v) should not be negative", cal.MaxRate)
+	}
+
+	switch cal.Smoothing {
+	case "":
+	case SmoothingMedian:
+		if cal.Samples == 0 {
+			cal.Samples = 5
+		}
+		if cal.Samples < 1 {
+			return fmt.Errorf("Calibration Init: Samples (%d) should be positive", cal.Samples)
+		}
+	case SmoothingEma:
+		if cal.Alpha == 0 {
+			cal.Alpha = 0.3
+		}
+		if cal.Alpha < 0 || cal.Alpha > 1 {
+			return fmt.Errorf("Calibration Init: Alpha (%v) out of 0-1 range", cal.Alpha)
+		}
+	default:
+		return fmt.Errorf("Calibration Init: unknown Smoothing (%s)", cal.Smoothing)
+	}
+
+	return nil
+}
+
+// getLimits returns plausible value range (DS18B20 range by default), nil safe.
+func (cal *Calibration) getLimits() (min float64, max float64) {
+	min, max = -55, 125
+	if cal == nil {
+		return
+	}
+	if cal.Min != nil {
+		min = *cal.Min
+	}
+	if cal.Max != nil {
+		max = *cal.Max
+	}
+
+	return
+}
+
+// correct returns raw value with two-point calibration and offset applied.
+func (cal *Calibration) correct(raw float64) float64 {
+	value := raw
+	if cal.RawLow != cal.RawHigh {
+		value = cal.RefLow + (raw-cal.RawLow)*(cal.RefHigh-cal.RefLow)/(cal.RawHigh-cal.RawLow)
+	}
+
+	return value + cal.Offset
+}
+
+// Apply returns corrected (and smoothed) value or error when readout is not plausible. It is nil
+// safe, without calibration only 85 °C and default range are checked.
+func (cal *Calibration) Apply(raw float64) (float64, error) {
+	if raw == powerOnValue && (cal == nil || !cal.Accept85) {
+		return 0, fmt.Errorf("power-on value (%v) rejected", raw)
+	}
+	if cal == nil {
+		min, max := cal.getLimits()
+		if raw < min || raw > max {
+			return 0, fmt.Errorf("value %v out of plausible range (%v-%v)", raw, min, max)
+		}
+		return raw, nil
+	}
+
+	value := cal.correct(raw)
+	min, max := cal.getLimits()
+	if value < min || value > max {
+		return 0, fmt.Errorf("value %v (raw %v) out of plausible range (%v-%v)", value, raw, min, max)
+	}
+
+	now := time.Now()
+	if cal.MaxRate > 0 && !cal.lastTime.IsZero() {
+		// allowed change grows with time since last accepted value
+		minutes := now.Sub(cal.lastTime).Minutes()
+		if math.Abs(value-cal.lastValue) > cal.MaxRate*minutes {
+			return 0, fmt.Errorf("value %v changed from %v in %.1f min, more than MaxRate (%v/min)", value, cal.lastValue, minutes, cal.MaxRate)
+		}
+	}
+	cal.lastValue = value
+	cal.lastTime = now
+
+	return cal.smooth(value), nil
+}
+
+// smooth returns value with Smoothing applied.
+func (cal *Calibration) smooth(value float64) float64 {
+	switch cal.Smoothing {
+	case SmoothingMedian:
+		cal.history = append(cal.history, value)
+		if len(cal.history) > cal.Samples {
+			cal.history = cal.history[len(cal.history)-cal.Samples:]
+		}
+		sorted := append([]float64{}, cal.history...)
+		sort.Float64s(sorted)
+		middle := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[middle-1] + sorted[middle]) / 2
+		}
+		return sorted[middle]
+	case SmoothingEma:
+		if !cal.primed {
+			cal.smoothed = value
+			cal.primed = true
+		}
+		cal.smoothed = cal.Alpha*value + (1-cal.Alpha)*cal.smoothed
+		return cal.smoothed
+	}
+
+	return value
+}
+
+// copyState copies rate and smoothing state from other calibration (e.g. from previous config).
+func (cal *Calibration) copyState(other *Calibration) {
+	cal.lastValue = other.lastValue
+	cal.lastTime = other.lastTime
+	if cal.Smoothing == other.Smoothing {
+		cal.history = append([]float64{}, other.history...)
+		cal.smoothed = other.smoothed
+		cal.primed = other.primed
+	}
+}
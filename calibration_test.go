@@ -0,0 +1,287 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
+func TestCalibrationInit(t *testing.T) {
+	tests := []struct {
+		name string
+		cal  Calibration
+		err  string
+	}{
+		{
+			name: "offset only",
+			cal:  Calibration{Offset: -0.5},
+		},
+		{
+			name: "same raw points",
+			cal:  Calibration{RawLow: 0.5, RefLow: 0, RawHigh: 0.5, RefHigh: 100},
+			err:  "RawLow and RawHigh (0.5) should differ",
+		},
+		{
+			name: "Min above Max",
+			cal:  Calibration{Min: floatPtr(30), Max: floatPtr(10)},
+			err:  "Min (30) should be lower than Max (10)",
+		},
+		{
+			name: "Min above default Max",
+			cal:  Calibration{Min: floatPtr(130)},
+			err:  "Min (130) should be lower than Max (125)",
+		},
+		{
+			name: "negative MaxRate",
+			cal:  Calibration{MaxRate: -1},
+			err:  "MaxRate (-1) should not be negative",
+		},
+		{
+			name: "unknown smoothing",
+			cal:  Calibration{Smoothing: "mean"},
+			err:  "unknown Smoothing (mean)",
+		},
+		{
+			name: "ema alpha",
+			cal:  Calibration{Smoothing: SmoothingEma, Alpha: 1.5},
+			err:  "Alpha (1.5) out of 0-1 range",
+		},
+	}
+
+	for _, test := range tests {
+		err := test.cal.Init()
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestCalibrationApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		cal      *Calibration
+		raw      float64
+		expected float64
+		err      string
+	}{
+		{
+			name:     "without calibration",
+			raw:      21.5,
+			expected: 21.5,
+		},
+		{
+			name:     "offset",
+			cal:      &Calibration{Offset: -0.5},
+			raw:      21.5,
+			expected: 21,
+		},
+		{
+			name:     "two-point, ice water",
+			cal:      &Calibration{RawLow: 0.5, RefLow: 0, RawHigh: 98.5, RefHigh: 100},
+			raw:      0.5,
+			expected: 0,
+		},
+		{
+			name:     "two-point, boiling water",
+			cal:      &Calibration{RawLow: 0.5, RefLow: 0, RawHigh: 98.5, RefHigh: 100},
+			raw:      98.5,
+			expected: 100,
+		},
+		{
+			name:     "two-point between points",
+			cal:      &Calibration{RawLow: 0.5, RefLow: 0, RawHigh: 98.5, RefHigh: 100},
+			raw:      49.5,
+			expected: 50,
+		},
+		{
+			name:     "two-point with offset",
+			cal:      &Calibration{RawLow: 0.5, RefLow: 0, RawHigh: 98.5, RefHigh: 100, Offset: 0.2},
+			raw:      49.5,
+			expected: 50.2,
+		},
+		{
+			name: "85 without calibration",
+			raw:  85,
+			err:  "power-on value (85) rejected",
+		},
+		{
+			name: "85 with calibration",
+			cal:  &Calibration{Offset: -0.5},
+			raw:  85,
+			err:  "power-on value (85) rejected",
+		},
+		{
+			name:     "85 accepted",
+			cal:      &Calibration{Accept85: true},
+			raw:      85,
+			expected: 85,
+		},
+		{
+			name:     "85 accepted and corrected",
+			cal:      &Calibration{Accept85: true, Offset: 1},
+			raw:      85,
+			expected: 86,
+		},
+		{
+			name: "above default range",
+			raw:  127,
+			err:  "value 127 out of plausible range (-55-125)",
+		},
+		{
+			name: "corrected below Min",
+			cal:  &Calibration{Offset: -2, Min: floatPtr(0)},
+			raw:  1,
+			err:  "value -1 (raw 1) out of plausible range (0-125)",
+		},
+	}
+
+	for _, test := range tests {
+		if test.cal != nil {
+			err := test.cal.Init()
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		value, err := test.cal.Apply(test.raw)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error %q, got: %v (value %v)", test.name, test.err, err, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !isClose(value, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, value)
+		}
+	}
+}
+
+func TestCalibrationMaxRate(t *testing.T) {
+	cal := &Calibration{MaxRate: 1}
+	err := cal.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		minutes float64 // since last accepted value
+		raw     float64
+		ok      bool
+	}{
+		{0, 20, true},    // first value is always accepted
+		{2, 21.5, true},  // 1.5 in 2 minutes
+		{1, 25, false},   // spike, 3.5 in 1 minute
+		{1, 23, false},   // rejected value is not a reference, 1.5 from 21.5 in 1 minute
+		{3, 23, true},    // allowed change grows with time
+		{10, 13.5, true}, // falling
+	}
+
+	for ix, step := range steps {
+		if !cal.lastTime.IsZero() {
+			cal.lastTime = time.Now().Add(-time.Duration(step.minutes * float64(time.Minute)))
+		}
+		value, err := cal.Apply(step.raw)
+		if step.ok && (err != nil || value != step.raw) {
+			t.Errorf("step %d: expected %v accepted, got %v, error: %v", ix, step.raw, value, err)
+		}
+		if !step.ok && (err == nil || !strings.Contains(err.Error(), "more than MaxRate (1/min)")) {
+			t.Errorf("step %d: expected %v rejected, got: %v", ix, step.raw, err)
+		}
+	}
+}
+
+func TestCalibrationSmoothing(t *testing.T) {
+	tests := []struct {
+		name     string
+		cal      *Calibration
+		raw      []float64
+		expected []float64
+	}{
+		{
+			name:     "median of 3",
+			cal:      &Calibration{Smoothing: SmoothingMedian, Samples: 3},
+			raw:      []float64{20, 30, 21, 22, 80, 23},
+			expected: []float64{20, 25, 21, 22, 22, 23},
+		},
+		{
+			name:     "median default samples",
+			cal:      &Calibration{Smoothing: SmoothingMedian},
+			raw:      []float64{20, 80, 21, 22, 80, 80, 23},
+			expected: []float64{20, 50, 21, 21.5, 22, 80, 23},
+		},
+		{
+			name:     "ema",
+			cal:      &Calibration{Smoothing: SmoothingEma, Alpha: 0.5},
+			raw:      []float64{20, 30, 30, 10},
+			expected: []float64{20, 25, 27.5, 18.75},
+		},
+		{
+			name:     "ema default alpha",
+			cal:      &Calibration{Smoothing: SmoothingEma},
+			raw:      []float64{20, 30},
+			expected: []float64{20, 23},
+		},
+	}
+
+	for _, test := range tests {
+		err := test.cal.Init()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for ix, raw := range test.raw {
+			value, err := test.cal.Apply(raw)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			if !isClose(value, test.expected[ix]) {
+				t.Errorf("%s: readout %d (%v) expected %v, got %v", test.name, ix, raw, test.expected[ix], value)
+			}
+		}
+	}
+}
+
+func TestSetFromInt(t *testing.T) {
+	slave := &OwSlave{Name: "tank", Calibration: &Calibration{Offset: -0.5}}
+	err := slave.Calibration.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = slave.SetFromInt(21500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slave.Raw != 21.5 || slave.Value != 21 || !slave.IsOk() {
+		t.Errorf("expected raw 21.5, value 21 and ok status, got raw %v, value %v, status %q", slave.Raw, slave.Value, slave.Status)
+	}
+
+	// rejected readout keeps last good value, raw is the rejected one
+	err = slave.SetFromInt(85000)
+	if err == nil {
+		t.Fatal("expected 85 °C readout rejected")
+	}
+	if slave.Raw != 85 || slave.Value != 21 {
+		t.Errorf("expected raw 85 and kept value 21, got raw %v, value %v", slave.Raw, slave.Value)
+	}
+
+	// without calibration negative readout and default range
+	slave = &OwSlave{Name: "outside"}
+	err = slave.SetFromInt(-10125)
+	if err != nil || slave.Value != -10.125 {
+		t.Errorf("expected value -10.125, got %v, error: %v", slave.Value, err)
+	}
+	err = slave.SetFromInt(125500)
+	if err == nil || slave.Value != -10.125 {
+		t.Errorf("expected 125.5 rejected and value kept, got %v, error: %v", slave.Value, err)
+	}
+}
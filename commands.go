@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const commandsUsage = `commands:
+  run                       run service (default)
+  validate [path]           check config file
+  scan                      list all 1-wire devices with raw data and crc status
+  read <sensor>             read single sensor (name, id or hex id, e.g. 28-0316a27955ff)
+  relay <gpio|sensor> on|off|status
+                            switch or check thermostat output (by gpio or sensor name, Invert and Output from config)
+  print-config              print effective config (with defaults)
+  test-writers              send sample value to each configured writer
+`
+
+// loadCommandConfig loads config (without touching hardware), when optional and config is not found
+// default settings are used (unless path was given explicitly).
+func loadCommandConfig(optional bool) (*OwSet, error) {
+	path, err := findConfigPath()
+	if err != nil {
+		if optional && len(getExplicitConfigPath()) == 0 {
+			wires := &OwSet{}
+			return wires, wires.Set()
+		}
+		return nil, err
+	}
+
+	content, err := readConfigFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	return newOwSetFromConfig(content)
+}
+
+// runValidate checks config file (given or found as on start) and prints all errors, returns exit code.
+func runValidate(args []string) int {
+	var path string
+	var err error
+	if len(args) > 0 {
+		path = args[0]
+	} else {
+		path, err = findConfigPath()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	content, err := readConfigFile(path)
+	if err != nil {
+		fmt.Printf("error reading config file: %v\n", err)
+		return 1
+	}
+
+	_, err = newOwSetFromConfig(content)
+	if err != nil {
+		var errs ConfigErrors
+		if errors.As(err, &errs) {
+			fmt.Printf("%s: %d error(s)\n", path, len(errs))
+			for _, ce := range errs {
+				fmt.Println(ce.Error())
+			}
+		} else {
+			fmt.Printf("%s: %v\n", path, err)
+		}
+		return 1
+	}
+
+	fmt.Printf("%s: config OK\n", path)
+	return 0
+}
+
+// runScan lists all devices found on the bus (sysfs or owserver, as configured).
+func runScan(args []string) int {
+	wires, err := loadCommandConfig(true)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	devices, err := wires.bus.Discover()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("found %d device(s)\n", len(devices))
+
+	for _, dev := range devices {
+		familyName := "unknown"
+		if fam := getOwFamily(dev.Family); fam != nil {
+			familyName = fam.Name
+		}
+		name := "-"
+		if slave := wires.GetSlaveById(dev.Id); slave != nil && len(slave.Name) > 0 {
+			name = slave.Name
+		}
+		fmt.Printf("\n%s\tfamily: %s (%s)\tid: %012x\tname: %s\n", dev, dev.Family, familyName, dev.Id, name)
+
+		if rawReader, ok := wires.bus.(BusRawReader); ok {
+			raw, err := rawReader.ReadRaw(dev)
+			if err != nil {
+				fmt.Printf("raw read failed: %v\n", err)
+			} else {
+				fmt.Print(string(raw))
+			}
+		}
+
+		readout, err := wires.bus.Read(dev)
+		if err != nil {
+			fmt.Printf("read failed: %v\n", err)
+			continue
+		}
+		fmt.Printf("crc ok: %v\tvalue: %.3f\n", readout.CrcOk, float64(readout.Value)/1000)
+	}
+
+	return 0
+}
+
+// runRead reads single sensor given by name, id or hex id.
+func runRead(args []string) int {
+	if len(args) < 1 {
+		fmt.Print(commandsUsage)
+		return 2
+	}
+
+	wires, err := loadCommandConfig(true)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	slave := wires.GetSlave(args[0])
+	if slave == nil {
+		slave = &OwSlave{HexId: args[0]}
+		if !slave.InitId() {
+			fmt.Printf("sensor %s not found in config and it is not a hex id\n", args[0])
+			return 1
+		}
+	}
+
+	if slave.Virtual != nil {
+		// virtual sensor is computed from all other sensors
+		for _, other := range wires.Sensors {
+			if other.Virtual == nil {
+				wires.refreshSlave(other)
+			}
+		}
+		wires.refreshVirtuals()
+		if len(slave.Status) == 0 {
+			fmt.Printf("virtual sensor %s needs more samples (%s), it is computed by running service\n", slave.Name, slave.Virtual.Function)
+			return 1
+		}
+		if !slave.IsOk() {
+			fmt.Printf("virtual sensor %s failed: %s\n", slave.Name, slave.LastError)
+			return 1
+		}
+		fmt.Printf("%s\tvirtual\t%.3f\n", slave.Name, slave.Value)
+		return 0
+	}
+
+	err = wires.refreshSlave(slave)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("%s\t%s-%012x\t%.3f\n", slave.Name, slave.Family, slave.Id, slave.Value)
+
+	return 0
+}
+
+// runRelay switches or reads gpio output.
+func runRelay(args []string) int {
+	if len(args) < 2 {
+		fmt.Print(commandsUsage)
+		return 2
+	}
+	gpio, err := strconv.Atoi(args[0])
+	byName := err != nil
+
+	th := &Thermo{Gpio: gpio, Sensor: &OwSlave{Name: "relay"}}
+	wires, err := loadCommandConfig(true)
+	if err == nil {
+		for _, slave := range wires.Sensors {
+			if slave.Thermostat == nil {
+				continue
+			}
+			if (byName && slave.Name == args[0]) || (!byName && slave.Thermostat.Output.IsGpio() && slave.Thermostat.Gpio == gpio) {
+				th = slave.Thermostat
+				fmt.Printf("%s used by thermostat of %s (invert: %v, output: %s)\n", args[0], slave.Name, th.Invert, th.Output.getKey(th.Gpio))
+			}
+		}
+	}
+	if byName && th.Sensor.Name == "relay" {
+		fmt.Printf("bad gpio number or thermostat name (%s)\n", args[0])
+		return 2
+	}
+
+	defer closeOutputs()
+	switch strings.ToLower(args[1]) {
+	case "on":
+		err = th.Set(true)
+	case "off":
+		err = th.Set(false)
+	case "status":
+		err = th.ReadState()
+	default:
+		fmt.Print(commandsUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("%s is on: %v\n", args[0], th.IsOn)
+
+	return 0
+}
+
+// runPrintConfig prints config with defaults set by OwSet.Set.
+func runPrintConfig(args []string) int {
+	wires, err := loadCommandConfig(false)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	js, err := json.MarshalIndent(wires, "", "\t")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(wires.redactSecrets(js)))
+
+	return 0
+}
+
+// runTestWriters sends sample sensor value through each configured writer and reports the result.
+func runTestWriters(args []string) int {
+	wires, err := loadCommandConfig(false)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	sample := &OwSlave{Name: "owkit-test"}
+	sample.SetFromInt(21500)
+	samples := []*OwSlave{sample}
+
+	result := 0
+	report := func(name string, err error) {
+		if err != nil {
+			fmt.Printf("%s: FAILED\n%v\n", name, err)
+			result = 1
+		} else {
+			fmt.Printf("%s: OK\n", name)
+		}
+	}
+
+	if wires.LogInflux == nil && wires.SendHttp == nil {
+		fmt.Println("no writers configured")
+	}
+	if wires.LogInflux != nil {
+		start := time.Now()
+		err = wires.LogInflux.Send(samples)
+		report(fmt.Sprintf("LogInflux (%s, %s)", wires.LogInflux.Host, time.Since(start).Round(time.Millisecond)), err)
+	}
+	if wires.SendHttp != nil {
+		start := time.Now()
+		err = wires.SendHttp.Send(samples)
+		report(fmt.Sprintf("SendHttp (%s %s, %s)", wires.SendHttp.Method, wires.SendHttp.Host, time.Since(start).Round(time.Millisecond)), err)
+	}
+
+	return result
+}
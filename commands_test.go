@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs command and returns its exit code and printed output.
+func captureStdout(t *testing.T, command func() int) (int, string) {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	printed := make(chan string)
+	go func() {
+		content, _ := ioutil.ReadAll(reader)
+		printed <- string(content)
+	}()
+
+	code := command()
+	writer.Close()
+
+	return code, <-printed
+}
+
+// writeTestConfig writes config file with given name to temp dir and returns its path.
+func writeTestConfig(t *testing.T, name string, config string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRunValidate(t *testing.T) {
+	good := writeTestConfig(t, "good.json", `{"Sensors": [{"Name": "tank", "HexId": "28-0316a27955ff",
+		"Thermostat": {"Gpio": 21, "Setpoint": 50, "Output": {"Driver": "fake"}}}]}`)
+	bad := writeTestConfig(t, "bad.json", `{"Sensors": [
+		{"Name": "tank", "HexId": "28-0316a27955ff", "Thermostat": {"Gpio": 21, "Setpiont": 50}},
+		{"Name": "tank", "HexId": "28-0316a27955aa", "Thermostat": {"Gpio": 28, "Setpoint": 50}}
+	]}`)
+	broken := writeTestConfig(t, "broken.json", `{"Sensors": [`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		code     int
+		expected []string
+	}{
+		{"valid config", []string{good}, 0, []string{good + ": config OK"}},
+		{"config errors with paths", []string{bad}, 1, []string{
+			bad + ": 3 error(s)",
+			"$.Sensors[0].Thermostat.Setpiont: unknown field",
+			"$.Sensors[1].Name: duplicated sensor name (tank), first used in $.Sensors[0]",
+			"$.Sensors[1].Thermostat.Gpio: gpio (28) out of 1-27 range",
+		}},
+		{"not json", []string{broken}, 1, []string{broken + ": "}},
+		{"missing file", []string{filepath.Join(t.TempDir(), "missing.json")}, 1, []string{"error reading config file"}},
+	}
+
+	for _, test := range tests {
+		code, printed := captureStdout(t, func() int { return runValidate(test.args) })
+		if code != test.code {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.code, code)
+		}
+		for _, line := range test.expected {
+			if !strings.Contains(printed, line) {
+				t.Errorf("%s: expected %q in output:\n%s", test.name, line, printed)
+			}
+		}
+	}
+}
+
+func TestRunRelay(t *testing.T) {
+	t.Setenv("OWKIT_CONFIG", writeTestConfig(t, "config.json", `{"Sensors": [
+		{"Name": "tank", "HexId": "28-0316a27955ff", "Thermostat": {"Gpio": 21, "Invert": true, "Setpoint": 50, "Output": {"Driver": "fake"}}},
+		{"Name": "boiler", "HexId": "28-0316a27955aa", "Thermostat": {"Gpio": 22, "Setpoint": 60, "Output": {"Driver": "fake"}}}
+	]}`))
+	t.Cleanup(closeOutputs)
+
+	tests := []struct {
+		name    string
+		args    []string
+		gpio    int
+		code    int
+		state   bool
+		printed string
+	}{
+		{"by name, inverted", []string{"tank", "on"}, 21, 0, false, "tank used by thermostat of tank (invert: true, output: fake::21)"},
+		{"by gpio", []string{"22", "on"}, 22, 0, true, "22 used by thermostat of boiler (invert: false, output: fake::22)"},
+		{"by gpio, inverted", []string{"21", "off"}, 21, 0, true, "21 is on: false"},
+		{"unknown name", []string{"garage", "on"}, 0, 2, false, "bad gpio number or thermostat name (garage)"},
+		{"bad action", []string{"tank", "toggle"}, 0, 2, false, "commands:"},
+	}
+
+	for _, test := range tests {
+		// fake outputs are dropped when command closes outputs, keep the one used by command
+		var out Output
+		if test.gpio > 0 {
+			var err error
+			out, err = getOutput(&OutputConfig{Driver: OutputFake}, test.gpio)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out.Set(!test.state)
+		}
+
+		code, printed := captureStdout(t, func() int { return runRelay(test.args) })
+		if code != test.code {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.code, code)
+		}
+		if !strings.Contains(printed, test.printed) {
+			t.Errorf("%s: expected %q in output:\n%s", test.name, test.printed, printed)
+		}
+		if out != nil {
+			if state, _ := out.Get(); state != test.state {
+				t.Errorf("%s: expected gpio %d output [%v], got [%v]", test.name, test.gpio, test.state, state)
+			}
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// newOwSetFromConfig parses and checks config, returned OwSet is not started (no hardware touched).
+func newOwSetFromConfig(content []byte) (*OwSet, error) {
+	next := &OwSet{}
+	err := next.parseConfig(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// GetConfig returns current config document (as loaded or last written).
+func (os *OwSet) GetConfig() (doc map[string]interface{}, err error) {
+	doc = map[string]interface{}{}
+	if len(os.config) == 0 {
+		return doc, nil
+	}
+	err = json.Unmarshal(os.config, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("OwSet GetConfig: parsing config failed:\n%w", err)
+	}
+
+	return doc, nil
+}
+
+// UpdateConfig validates new config document, applies it and writes it to config file (previous version
+// is kept as backup with .bak suffix). When writing fails previous config is applied back. Only json
+// config files are written (see checkConfigWritable).
+// Caller holds configLock (see lockConfig), so read-modify-write of config document isn't interleaved.
+func (os *OwSet) UpdateConfig(doc map[string]interface{}) error {
+	if len(os.configPath) > 0 {
+		err := checkConfigWritable(os.configPath)
+		if err != nil {
+			return fmt.Errorf("OwSet UpdateConfig: %w", err)
+		}
+	}
+
+	content, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return fmt.Errorf("OwSet UpdateConfig: json marshal failed:\n%w", err)
+	}
+
+	next, err := newOwSetFromConfig(content)
+	if err != nil {
+		return fmt.Errorf("OwSet UpdateConfig: config is not valid:\n%w", err)
+	}
+
+	previous := os.config
+	err = os.Apply(next)
+	if err != nil {
+		return fmt.Errorf("OwSet UpdateConfig: applying config failed:\n%w", err)
+	}
+	if len(os.configPath) == 0 {
+		return nil
+	}
+
+	err = backupFile(os.configPath)
+	if err == nil {
+		err = writeFileAtomic(os.configPath, content)
+	}
+	if err != nil {
+		os.rollbackConfig(previous)
+		return fmt.Errorf("OwSet UpdateConfig: writing config file failed, previous config applied back:\n%w", err)
+	}
+	os.Log(fmt.Sprintf("Config written to %s", os.configPath))
+	os.configModTime = getModTime(os.configPath)
+
+	return nil
+}
+
+// rollbackConfig applies previous config document again (after failed config file write).
+func (os *OwSet) rollbackConfig(previous []byte) {
+	restored, err := newOwSetFromConfig(previous)
+	if err == nil {
+		err = os.Apply(restored)
+	}
+	if err != nil {
+		log.Printf("ERROR | OwSet | config rollback failed:\n%v", err)
+	}
+}
+
+// lockConfig serialises config changes with cycle and other changes, returns unlock function.
+func (os *OwSet) lockConfig() func() {
+	os.configLock.Lock()
+
+	return os.configLock.Unlock
+}
+
+func backupFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path+".bak", content)
+}
+
+// Apply replaces running config with next one (already parsed). Readouts of sensors present
+// in both configs are kept. Thermostats with unchanged config are kept untouched (with runtime
+// state and outputs), new or changed ones read state from outputs. Outputs of removed or moved
+// thermostats are released (see releaseThermostats). Ticker is restarted if refresh interval changed.
+// Caller holds configLock (api, reload), so Apply doesn't run with cycle or other config change.
+func (os *OwSet) Apply(next *OwSet) error {
+	previousThermos := getConfigThermostats(os.config)
+	nextThermos := getConfigThermostats(next.config)
+	configStates, _ := json.Marshal(next.getThermoStates())
+
+	var kept []string
+	for _, slave := range next.Sensors {
+		if slave.Thermostat == nil {
+			continue
+		}
+		key := getSlaveKey(slave)
+		previous := os.GetSlave(key)
+		_, wasConfigured := previousThermos[key]
+		if previous != nil && previous.Thermostat != nil && wasConfigured && reflect.DeepEqual(previousThermos[key], nextThermos[key]) {
+			slave.Thermostat = previous.Thermostat
+			kept = append(kept, key)
+			continue
+		}
+
+		err := slave.Thermostat.ReadState()
+		if err != nil {
+			return fmt.Errorf("OwSet Apply: (%s) thermostat ReadState failed:\n%w", slave.Name, err)
+		}
+		if previous != nil && previous.Thermostat != nil {
+			slave.Thermostat.setStageCounters(previous.Thermostat.getStageCounters())
+		}
+	}
+
+	os.blocker.Lock()
+	defer os.blocker.Unlock()
+
+	os.releaseThermostats(next)
+
+	for _, slave := range next.Sensors {
+		previous := os.GetSlave(getSlaveKey(slave))
+		if previous == nil && slave.Id != 0 {
+			previous = os.GetSlaveById(slave.Id)
+		}
+		if previous != nil {
+			slave.copyReadout(previous)
+			if previous.Virtual != nil && slave.Virtual != nil {
+				slave.Virtual.samples = previous.Virtual.samples
+			}
+			if previous.Calibration != nil && slave.Calibration != nil {
+				slave.Calibration.copyState(previous.Calibration)
+			}
+		}
+		if slave.Thermostat != nil {
+			slave.Thermostat.Sensor = slave
+		}
+	}
+	// sensors found on bus, but not configured (see InitSlaves) are kept
+	for _, slave := range os.Sensors {
+		if len(slave.Name) == 0 && slave.Thermostat == nil && next.GetSlaveById(slave.Id) == nil {
+			next.Sensors = append(next.Sensors, slave)
+		}
+	}
+
+	os.Path = next.Path
+	os.SlavePrefix = next.SlavePrefix
+	os.Debug = next.Debug
+	os.Sensors = next.Sensors
+	os.OwServer = next.OwServer
+	os.LogInflux = next.LogInflux
+	os.SendHttp = next.SendHttp
+	os.OffPeak = next.OffPeak
+	os.EnergyPanel = next.EnergyPanel
+	os.StateFile = next.StateFile
+	os.ReadRetries = next.ReadRetries
+	os.StaleSeconds = next.StaleSeconds
+	os.bus = next.bus
+	os.config = next.config
+	os.secrets = next.secrets
+
+	if os.RefreshSeconds != next.RefreshSeconds {
+		os.Log(fmt.Sprintf("OwSet Apply: refresh interval changed (%ds -> %ds)", os.RefreshSeconds, next.RefreshSeconds))
+		os.RefreshSeconds = next.RefreshSeconds
+		os.refreshInterval = next.refreshInterval
+		if os.tick != nil {
+			os.tick.Reset(os.refreshInterval)
+		}
+	}
+
+	if os.Server != nil && next.Server != nil {
+		if os.Server.Port != next.Server.Port {
+			os.Log("OwSet Apply: Server Port change requires restart")
+		}
+		os.Server.IntMultiFactor = next.Server.IntMultiFactor
+		os.Server.User = next.Server.User
+		os.Server.Password = next.Server.Password
+	}
+
+	os.stateLock.Lock()
+	os.configStates = configStates
+	os.stateLock.Unlock()
+
+	os.Log(fmt.Sprintf("New config applied, unchanged thermostats kept: %v", kept))
+
+	return nil
+}
+
+// releaseThermostats sets outputs of thermostats removed or moved to other output by next config
+// to their ShutdownState (see Thermo Release), outputs used by next config are left to it.
+func (os *OwSet) releaseThermostats(next *OwSet) {
+	inUse := map[string]bool{}
+	nextThermos := map[*Thermo]bool{}
+	for _, slave := range next.Sensors {
+		if slave.Thermostat == nil {
+			continue
+		}
+		nextThermos[slave.Thermostat] = true
+		for _, key := range slave.Thermostat.getOutputKeys() {
+			inUse[key] = true
+		}
+	}
+
+	for _, slave := range os.Sensors {
+		if slave.Thermostat == nil || nextThermos[slave.Thermostat] {
+			continue
+		}
+		err := slave.Thermostat.Release(inUse)
+		if err != nil {
+			log.Printf("ERROR | OwSet | (%s) releasing thermostat output failed:\n%v", slave.Name, err)
+		}
+	}
+}
+
+// Reload reads config file again and applies it, on error running config is left untouched.
+// Reload waits for running cycle and other config changes (see lockConfig).
+func (os *OwSet) Reload() error {
+	defer os.lockConfig()()
+
+	return os.reload()
+}
+
+// reload reads and applies config file, caller holds configLock.
+func (os *OwSet) reload() error {
+	if len(os.configPath) == 0 {
+		return fmt.Errorf("OwSet Reload: config path unknown")
+	}
+
+	content, err := readConfigFile(os.configPath)
+	if err != nil {
+		return fmt.Errorf("OwSet Reload: error reading config file:\n%w", err)
+	}
+	next, err := newOwSetFromConfig(content)
+	if err != nil {
+		return fmt.Errorf("OwSet Reload: config is not valid, keeping running one:\n%w", err)
+	}
+
+	os.Log(fmt.Sprintf("Reloading config from %s", os.configPath))
+	err = os.Apply(next)
+	if err != nil {
+		return fmt.Errorf("OwSet Reload:\n%w", err)
+	}
+	os.configModTime = getModTime(os.configPath)
+
+	return nil
+}
+
+// watchConfigFile checks config file modification time every interval and reloads it on change.
+func (os *OwSet) watchConfigFile(interval time.Duration) {
+	unlock := os.lockConfig()
+	os.configModTime = getModTime(os.configPath)
+	unlock()
+
+	for range time.Tick(interval) {
+		err := os.reloadIfChanged()
+		if err != nil {
+			log.Printf("ERROR | OwSet | config reload:\n%v", err)
+		}
+	}
+}
+
+// reloadIfChanged reloads config file when its modification time differs from the one of last
+// load or write (compared under configLock, so own writes by config api are not reloaded).
+func (os *OwSet) reloadIfChanged() error {
+	defer os.lockConfig()()
+
+	modTime := getModTime(os.configPath)
+	if modTime.IsZero() || modTime.Equal(os.configModTime) {
+		return nil
+	}
+	os.configModTime = modTime
+
+	os.Log("Config file changed")
+	return os.reload()
+}
+
+func getModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// getConfigThermostats returns thermostat config entries by sensor key (see getSlaveKey).
+func getConfigThermostats(config []byte) map[string]interface{} {
+	thermos := map[string]interface{}{}
+
+	doc := struct {
+		Sensors []map[string]interface{}
+	}{}
+	json.Unmarshal(config, &doc)
+
+	for _, sensor := range doc.Sensors {
+		thermo, found := sensor["Thermostat"]
+		if !found || thermo == nil {
+			continue
+		}
+		slave := &OwSlave{}
+		slave.Name, _ = sensor["Name"].(string)
+		slave.HexId, _ = sensor["HexId"].(string)
+		if id, ok := sensor["Id"].(float64); ok {
+			slave.Id = uint64(id)
+		}
+		slave.InitId()
+		thermos[getSlaveKey(slave)] = thermo
+	}
+
+	return thermos
+}
+
+// findConfigSensor returns index of sensor in config document matching given name, hex id or id.
+func findConfigSensor(doc map[string]interface{}, ident string) (sensors []interface{}, index int) {
+	sensors, _ = doc["Sensors"].([]interface{})
+	intId, errId := strconv.ParseUint(ident, 10, 64)
+
+	for ix, elem := range sensors {
+		sensor, ok := elem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if sensor["Name"] == ident || sensor["HexId"] == ident {
+			return sensors, ix
+		}
+		if id, ok := sensor["Id"].(float64); ok && errId == nil && uint64(id) == intId {
+			return sensors, ix
+		}
+	}
+
+	return sensors, -1
+}
+
+// mergePatch applies json merge patch (RFC 7386) to target.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
+	}
+
+	for key, value := range patchMap {
+		if value == nil {
+			delete(targetMap, key)
+		} else {
+			targetMap[key] = mergePatch(targetMap[key], value)
+		}
+	}
+
+	return targetMap
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// authorize wraps handler with basic auth check (Server User and Password).
+func (srv *Server) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(srv.User) == 0 || len(srv.Password) == 0 {
+			http.Error(w, "Config api disabled (no User/Password in Server config)", http.StatusForbidden)
+			return
+		}
+
+		user, password, ok := r.BasicAuth()
+		userOk := subtle.ConstantTimeCompare([]byte(user), []byte(srv.User)) == 1
+		passwordOk := subtle.ConstantTimeCompare([]byte(password), []byte(srv.Password)) == 1
+		if !ok || !userOk || !passwordOk {
+			w.Header().Set("WWW-Authenticate", `Basic realm="owkit"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	js, err := json.MarshalIndent(value, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// getUpdateStatus returns http status for failed config update: conflict for config file not written by
+// api (yaml or toml), bad request for invalid config.
+func getUpdateStatus(err error) int {
+	if errors.Is(err, ErrConfigNotWritable) {
+		return http.StatusConflict
+	}
+
+	return http.StatusBadRequest
+}
+
+// HandleConfig returns (GET), replaces (PUT) or merges json merge patch (PATCH) into config.
+func (srv *Server) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
+	doc, err := srv.set.GetConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, doc)
+		return
+	case http.MethodPut, http.MethodPatch:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body map[string]interface{}
+	err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPatch {
+		body = mergePatch(doc, body).(map[string]interface{})
+	}
+
+	err = srv.set.UpdateConfig(body)
+	if err != nil {
+		http.Error(w, err.Error(), getUpdateStatus(err))
+		return
+	}
+
+	writeJson(w, body)
+}
+
+// HandleConfigSensor returns (GET), adds or replaces (PUT), merges patch (PATCH) or removes (DELETE)
+// single sensor entry (with its thermostat) in config: /config/sensors/<name|hexid|id>.
+func (srv *Server) HandleConfigSensor(w http.ResponseWriter, r *http.Request) {
+	ident := strings.TrimPrefix(r.URL.Path, "/config/sensors/")
+	if len(ident) == 0 {
+		http.Error(w, "Bad request (missing sensor)", http.StatusBadRequest)
+		return
+	}
+
+	defer srv.set.lockConfig()()
+
+	doc, err := srv.set.GetConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sensors, index := findConfigSensor(doc, ident)
+
+	var sensor interface{}
+	if index >= 0 {
+		sensor = sensors[index]
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if index < 0 {
+			http.Error(w, "Sensor not found in config", 404)
+			return
+		}
+		writeJson(w, sensor)
+		return
+	case http.MethodDelete:
+		if index < 0 {
+			http.Error(w, "Sensor not found in config", 404)
+			return
+		}
+		sensors = append(sensors[:index], sensors[index+1:]...)
+		sensor = nil
+	case http.MethodPut, http.MethodPatch:
+		var body map[string]interface{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			if index < 0 {
+				http.Error(w, "Sensor not found in config", 404)
+				return
+			}
+			sensor = mergePatch(sensor, body)
+		} else {
+			sensor = body
+		}
+		if index < 0 {
+			sensors = append(sensors, sensor)
+		} else {
+			sensors[index] = sensor
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc["Sensors"] = sensors
+	err = srv.set.UpdateConfig(doc)
+	if err != nil {
+		http.Error(w, err.Error(), getUpdateStatus(err))
+		return
+	}
+
+	writeJson(w, sensor)
+}
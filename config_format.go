@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configJson = "json"
+	configYaml = "yaml"
+	configToml = "toml"
+)
+
+// getConfigFormat returns config format by file extension (json is default).
+func getConfigFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return configYaml
+	case ".toml":
+		return configToml
+	default:
+		return configJson
+	}
+}
+
+// readConfigFile reads config file (json, yaml or toml) and returns it as json, so the same OwSet structure
+// (and json field names) are used for all formats.
+func readConfigFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	switch getConfigFormat(path) {
+	case configYaml:
+		err = yaml.Unmarshal(content, &doc)
+		doc = convertYamlMaps(doc)
+	case configToml:
+		var tomlDoc map[string]interface{}
+		_, err = toml.Decode(string(content), &tomlDoc)
+		doc = tomlDoc
+	default:
+		return content, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("readConfigFile: parsing %s (%s) failed:\n%w", path, getConfigFormat(path), err)
+	}
+
+	return json.Marshal(doc)
+}
+
+// ErrConfigNotWritable is returned by config api for yaml and toml config files, written back they
+// would lose comments and formatting (config api writes json config files only).
+var ErrConfigNotWritable = errors.New("config api writes only json config files, edit yaml or toml config and reload it")
+
+// checkConfigWritable returns ErrConfigNotWritable when config file is not json.
+func checkConfigWritable(path string) error {
+	if format := getConfigFormat(path); format != configJson {
+		return fmt.Errorf("%s config (%s): %w", format, path, ErrConfigNotWritable)
+	}
+
+	return nil
+}
+
+// convertYamlMaps converts map[interface{}]interface{} (decoded by yaml for non-string keys, e.g. numbers)
+// to map[string]interface{}.
+func convertYamlMaps(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, elem := range v {
+			converted[fmt.Sprint(key)] = convertYamlMaps(elem)
+		}
+		return converted
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = convertYamlMaps(elem)
+		}
+		return v
+	case []interface{}:
+		for ix, elem := range v {
+			v[ix] = convertYamlMaps(elem)
+		}
+		return v
+	default:
+		return value
+	}
+}
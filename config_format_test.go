@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const testYamlConfig = `
+Path: /nonexistent
+Sensors:
+  - Name: tank
+    HexId: 28-0316a27955ff
+    Thermostat:
+      Gpio: 21
+      Setpoint: 60
+      ShutdownState: off
+      Output:
+        Driver: fake
+      Failsafe:
+        Mode: on
+`
+
+func TestYamlConfigOnOff(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(configPath, []byte(testYamlConfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		t.Helper()
+		content, err := readConfigFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wires := &OwSet{}
+		err = wires.parseConfig(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		th := wires.GetSlaveByName("tank").Thermostat
+		if th.ShutdownState != "off" || th.Failsafe.Mode != "on" {
+			t.Errorf("expected off/on strings, got ShutdownState %q, Failsafe Mode %q", th.ShutdownState, th.Failsafe.Mode)
+		}
+	}
+	check()
+}
+
+func TestYamlConfigNotWritable(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(configPath, []byte(testYamlConfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeOutputs)
+	wires := &OwSet{}
+	err = wires.Set(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := wires.GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc["RefreshSeconds"] = 30
+	err = wires.UpdateConfig(doc)
+	if !errors.Is(err, ErrConfigNotWritable) {
+		t.Fatalf("expected config not writable error, got: %v", err)
+	}
+	if status := getUpdateStatus(err); status != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, status)
+	}
+
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != testYamlConfig {
+		t.Errorf("yaml config file should not be changed, got:\n%s", content)
+	}
+	if isCorrectFile(configPath + ".bak") {
+		t.Error("backup should not be written")
+	}
+	if current, _ := wires.GetConfig(); current["RefreshSeconds"] != nil {
+		t.Error("refused config should not be applied")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testApiConfig = `{
+	"Path": "/nonexistent",
+	"Server": {"Port": 8099, "User": "admin", "Password": "s3cret"},
+	"Sensors": [
+		{"Name": "tank", "HexId": "28-0316a27955ff", "Thermostat": {"Gpio": 26, "Setpoint": 25, "Output": {"Driver": "fake"}}}
+	]
+}`
+
+// newTestApiSet returns OwSet loaded from config file in temp dir.
+func newTestApiSet(t *testing.T, config string) (*OwSet, string) {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(configPath, []byte(config), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeOutputs)
+
+	wires := &OwSet{}
+	err = wires.Set(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return wires, configPath
+}
+
+func TestConfigConcurrentUpdates(t *testing.T) {
+	wires, configPath := newTestApiSet(t, testApiConfig)
+
+	var wait sync.WaitGroup
+	for ix := 0; ix < 8; ix++ {
+		wait.Add(1)
+		go func(ix int) {
+			defer wait.Done()
+			body := fmt.Sprintf(`{"Name": "sensor%d", "Id": %d}`, ix, ix+1)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("PUT", fmt.Sprintf("/config/sensors/sensor%d", ix), strings.NewReader(body))
+			wires.Server.HandleConfigSensor(rec, req)
+			if rec.Code != 200 {
+				t.Errorf("sensor%d: %d %s", ix, rec.Code, rec.Body.String())
+			}
+		}(ix)
+	}
+	wait.Wait()
+
+	written, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ix := 0; ix < 8; ix++ {
+		name := fmt.Sprintf("sensor%d", ix)
+		if wires.GetSlaveByName(name) == nil {
+			t.Errorf("%s lost in running config", name)
+		}
+		if !strings.Contains(string(written), `"`+name+`"`) {
+			t.Errorf("%s lost in config file", name)
+		}
+	}
+}
+
+func TestConfigWriteFailureRollsBack(t *testing.T) {
+	wires, _ := newTestApiSet(t, testApiConfig)
+	// config path pointing to directory can't be backed up nor written
+	wires.configPath = t.TempDir()
+
+	doc, err := wires.GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc["RefreshSeconds"] = 30
+	doc["Sensors"] = []interface{}{map[string]interface{}{"Name": "other", "Id": 5}}
+
+	err = wires.UpdateConfig(doc)
+	if err == nil {
+		t.Fatal("expected error writing config")
+	}
+	if wires.RefreshSeconds != 15 {
+		t.Errorf("expected previous refresh interval, got %d", wires.RefreshSeconds)
+	}
+	tank := wires.GetSlaveByName("tank")
+	if tank == nil || tank.Thermostat == nil || wires.GetSlaveByName("other") != nil {
+		t.Error("expected previous sensors after failed config write")
+	}
+}
+
+func TestConfigReleasesOutputs(t *testing.T) {
+	wires, _ := newTestApiSet(t, testApiConfig)
+	out, err := getOutput(&OutputConfig{Driver: OutputFake}, 26)
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(thermo map[string]interface{}) {
+		t.Helper()
+		doc, err := wires.GetConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sensor := map[string]interface{}{"Name": "tank", "HexId": "28-0316a27955ff"}
+		if thermo != nil {
+			thermo["Output"] = map[string]interface{}{"Driver": "fake"}
+			sensor["Thermostat"] = thermo
+		}
+		doc["Sensors"] = []interface{}{sensor}
+		err = wires.UpdateConfig(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// changed setpoint, output stays with thermostat
+	out.Set(true)
+	update(map[string]interface{}{"Gpio": 26, "Setpoint": 30})
+	if state, _ := out.Get(); !state {
+		t.Error("output still used by thermostat should not be switched")
+	}
+
+	// moved to other gpio, hold is treated as off
+	update(map[string]interface{}{"Gpio": 25, "Setpoint": 30, "ShutdownState": "on"})
+	if state, _ := out.Get(); state {
+		t.Error("output of moved thermostat should be switched off")
+	}
+
+	// removed, ShutdownState on
+	moved, err := getOutput(&OutputConfig{Driver: OutputFake}, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved.Set(false)
+	update(nil)
+	if state, _ := moved.Get(); !state {
+		t.Error("output of removed thermostat should be set to ShutdownState on")
+	}
+}
+
+func TestConfigReloadSerialised(t *testing.T) {
+	wires, configPath := newTestApiSet(t, testApiConfig)
+
+	var wait sync.WaitGroup
+	run := func(action func(ix int)) {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for ix := 0; ix < 10; ix++ {
+				action(ix)
+			}
+		}()
+	}
+	run(func(int) {
+		err := wires.Reload()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	run(func(ix int) {
+		// rewritten file is reloaded by watcher
+		writeFileAtomic(configPath, []byte(testApiConfig))
+		err := wires.reloadIfChanged()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	run(func(ix int) {
+		body := fmt.Sprintf(`{"RefreshSeconds": %d}`, 10+ix)
+		rec := httptest.NewRecorder()
+		wires.Server.HandleConfig(rec, httptest.NewRequest("PATCH", "/config", strings.NewReader(body)))
+		if rec.Code != 200 {
+			t.Errorf("PATCH: %d %s", rec.Code, rec.Body.String())
+		}
+	})
+	run(func(int) {
+		wires.cycle()
+	})
+	wait.Wait()
+
+	// after last change file and running config match
+	wires.reloadIfChanged()
+	if wires.GetSlaveByName("tank") == nil || wires.GetSlaveByName("tank").Thermostat == nil {
+		t.Error("expected tank thermostat after reloads")
+	}
+}
+
+func TestConfigReleaseKeepsOutputsInUse(t *testing.T) {
+	wires, _ := newTestApiSet(t, `{
+		"Path": "/nonexistent",
+		"Sensors": [
+			{"Name": "tank", "Id": 1, "Thermostat": {"Gpio": 21, "Setpoint": 25, "Output": {"Driver": "fake"}}},
+			{"Name": "boiler", "Id": 2, "Thermostat": {"Setpoint": 60, "Output": {"Driver": "fake"}, "Stages": [{"Gpio": 22}, {"Gpio": 23}]}}
+		]
+	}`)
+	fakes := map[int]Output{}
+	for gpio := 21; gpio <= 24; gpio++ {
+		out, err := getOutput(&OutputConfig{Driver: OutputFake}, gpio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Set(true)
+		fakes[gpio] = out
+	}
+
+	// tank thermostat moved to other sensor (same gpio), boiler stage 2 moved to gpio 24
+	next, err := newOwSetFromConfig([]byte(`{
+		"Path": "/nonexistent",
+		"Sensors": [
+			{"Name": "tank", "Id": 1},
+			{"Name": "tank-top", "Id": 3, "Thermostat": {"Gpio": 21, "Setpoint": 25, "Output": {"Driver": "fake"}}},
+			{"Name": "boiler", "Id": 2, "Thermostat": {"Setpoint": 60, "Output": {"Driver": "fake"}, "Stages": [{"Gpio": 22}, {"Gpio": 24}]}}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wires.releaseThermostats(next)
+
+	expected := map[int]bool{21: true, 22: true, 23: false, 24: true}
+	for gpio, state := range expected {
+		if actual, _ := fakes[gpio].Get(); actual != state {
+			t.Errorf("gpio %d: expected [%v] after release, got [%v]", gpio, state, actual)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	FailsafeOff  = "off"
+	FailsafeOn   = "on"
+	FailsafeHold = "hold"
+	FailsafeDuty = "duty"
+)
+
+// Failsafe defines thermostat behaviour when its sensor failed or its value is too old.
+type Failsafe struct {
+	Mode          string
+	AfterFailures int `json:",omitempty"`
+	MaxAgeSeconds int `json:",omitempty"`
+
+	// duty mode: output is on for DutyPercent of every DutyMinutes period
+	DutyPercent float64 `json:",omitempty"`
+	DutyMinutes int     `json:",omitempty"`
+
+	Active bool
+	Since  time.Time `json:",omitempty"`
+}
+
+func (fs *Failsafe) Init() error {
+	switch fs.Mode {
+	case "":
+		fs.Mode = FailsafeOff
+	case FailsafeOff, FailsafeOn, FailsafeHold:
+	case FailsafeDuty:
+		if fs.DutyPercent < 0 || fs.DutyPercent > 100 {
+			return fmt.Errorf("Failsafe Init: DutyPercent (%v) out of 0-100 range", fs.DutyPercent)
+		}
+		if fs.DutyMinutes == 0 {
+			fs.DutyMinutes = 10
+		}
+	default:
+		return fmt.Errorf("Failsafe Init: unknown mode (%s)", fs.Mode)
+	}
+
+	if fs.AfterFailures == 0 && fs.MaxAgeSeconds == 0 {
+		fs.AfterFailures = 3
+	}
+
+	return nil
+}
+
+func (fs *Failsafe) isFailed(sensor *OwSlave) bool {
+	if fs.AfterFailures > 0 && sensor.Failures >= fs.AfterFailures {
+		return true
+	}
+	if fs.MaxAgeSeconds > 0 && sensor.Age() > time.Duration(fs.MaxAgeSeconds)*time.Second {
+		return true
+	}
+
+	return false
+}
+
+// Check updates failsafe state using sensor status and returns true if failsafe is active.
+func (fs *Failsafe) Check(sensor *OwSlave) bool {
+	failed := fs.isFailed(sensor)
+
+	if failed && !fs.Active {
+		fs.Active = true
+		fs.Since = time.Now()
+		log.Printf("FAILSAFE Thermo (%s): sensor status %s (failures: %d, last error: %s), switching to failsafe mode: %s", sensor.Name, sensor.Status, sensor.Failures, sensor.LastError, fs.Mode)
+	}
+	if !failed && fs.Active {
+		fs.Active = false
+		log.Printf("FAILSAFE Thermo (%s): sensor recovered after %s, leaving failsafe mode", sensor.Name, time.Since(fs.Since).Round(time.Second))
+		fs.Since = time.Time{}
+	}
+
+	return fs.Active
+}
+
+// GetState returns output state required by failsafe mode.
+func (fs *Failsafe) GetState(current bool) bool {
+	switch fs.Mode {
+	case FailsafeOn:
+		return true
+	case FailsafeHold:
+		return current
+	case FailsafeDuty:
+		period := time.Duration(fs.DutyMinutes) * time.Minute
+		elapsed := time.Since(fs.Since) % period
+		return elapsed < time.Duration(float64(period)*fs.DutyPercent/100)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Feedback is gpio input confirming thermostat output state (e.g. contactor auxiliary contact),
+// active when low (input has pull up), Invert reverses it. It is checked DelaySeconds (default 2)
+// after output was written. Driver (and Chip) default to thermostat gpio output driver.
+type Feedback struct {
+	Gpio         int
+	Invert       bool
+	Driver       string `json:",omitempty"`
+	Chip         string `json:",omitempty"`
+	DelaySeconds int    `json:",omitempty"`
+}
+
+// Init sets defaults and checks feedback config.
+func (fb *Feedback) Init(output *OutputConfig) error {
+	if fb.Gpio == 0 {
+		return fmt.Errorf("Feedback Init: missing Gpio")
+	}
+	if len(fb.Driver) == 0 {
+		if output.IsGpio() {
+			fb.Driver = output.Driver
+			fb.Chip = output.Chip
+		} else {
+			fb.Driver = OutputRpio
+		}
+	}
+	if fb.DelaySeconds == 0 {
+		fb.DelaySeconds = 2
+	}
+
+	input := fb.getInputConfig()
+	err := input.Init()
+	if err != nil {
+		return fmt.Errorf("Feedback Init: %w", err)
+	}
+	if !input.IsGpio() {
+		return fmt.Errorf("Feedback Init: driver %s doesn't support inputs", fb.Driver)
+	}
+	fb.Chip = input.Chip
+
+	return nil
+}
+
+func (fb *Feedback) getInputConfig() *OutputConfig {
+	return &OutputConfig{Driver: fb.Driver, Chip: fb.Chip}
+}
+
+// Get returns true when feedback confirms that output is on.
+func (fb *Feedback) Get() (bool, error) {
+	in, err := getInput(fb.getInputConfig(), fb.Gpio)
+	if err != nil {
+		return false, fmt.Errorf("Feedback Get: input failed:\n%w", err)
+	}
+
+	state, err := in.Get()
+	if err != nil {
+		return false, fmt.Errorf("Feedback Get: reading input failed:\n%w", err)
+	}
+
+	return state != fb.Invert, nil
+}
+
+// checkedOutput is output (or stage output) with state it should have.
+type checkedOutput struct {
+	name     string
+	out      Output
+	expected bool
+}
+
+// getCheckedOutputs returns thermostat output or outputs of all stages.
+func (th *Thermo) getCheckedOutputs() (outs []checkedOutput, err error) {
+	if len(th.Stages) == 0 {
+		out, err := getOutput(th.Output, th.Gpio)
+		if err != nil {
+			return nil, err
+		}
+		return []checkedOutput{{"output", out, th.IsOn}}, nil
+	}
+
+	for ix, stage := range th.Stages {
+		out, err := getOutput(stage.Output, stage.Gpio)
+		if err != nil {
+			return nil, err
+		}
+		outs = append(outs, checkedOutput{fmt.Sprintf("stage %d", ix+1), out, stage.IsOn})
+	}
+
+	return
+}
+
+// checkOutput reads output back (and feedback input, if configured) and compares it with IsOn
+// (state of each stage for staged thermostat). On mismatch output is written again, after
+// AlarmAfter mismatches in a row Alarm is raised.
+func (th *Thermo) checkOutput() error {
+	outs, err := th.getCheckedOutputs()
+	if err != nil {
+		return fmt.Errorf("Thermo checkOutput: output failed:\n%w", err)
+	}
+
+	var mismatched []checkedOutput
+	for _, checked := range outs {
+		actual, err := checked.out.Get()
+		if err != nil {
+			return fmt.Errorf("Thermo checkOutput: reading %s failed:\n%w", checked.name, err)
+		}
+		actual = actual != th.Invert
+		if actual != checked.expected {
+			log.Printf("Thermo checkOutput: (%s) %s is [%v], expected [%v]", th.Sensor.Name, checked.name, actual, checked.expected)
+			mismatched = append(mismatched, checked)
+		}
+	}
+
+	if len(mismatched) == 0 && th.Feedback != nil {
+		if time.Since(th.lastWrite) < time.Duration(th.Feedback.DelaySeconds)*time.Second {
+			// feedback not settled yet, mismatch counter is kept
+			return nil
+		}
+		actual, err := th.Feedback.Get()
+		if err != nil {
+			return fmt.Errorf("Thermo checkOutput: %w", err)
+		}
+		if actual != th.IsOn {
+			log.Printf("Thermo checkOutput: (%s) feedback is [%v], expected [%v]", th.Sensor.Name, actual, th.IsOn)
+			mismatched = outs
+		}
+	}
+
+	if len(mismatched) == 0 {
+		if th.Alarm {
+			log.Printf("Thermo checkOutput: (%s) output alarm cleared", th.Sensor.Name)
+		}
+		th.Mismatches = 0
+		th.Alarm = false
+		return nil
+	}
+
+	th.Mismatches++
+	log.Printf("Thermo checkOutput: (%s) mismatch %d, setting output again", th.Sensor.Name, th.Mismatches)
+	if th.Mismatches >= th.AlarmAfter && !th.Alarm {
+		th.Alarm = true
+		log.Printf("ALARM | Thermo checkOutput: (%s) output doesn't follow requested state [%v] after %d checks", th.Sensor.Name, th.IsOn, th.Mismatches)
+	}
+
+	for _, checked := range mismatched {
+		err = checked.out.Set(checked.expected != th.Invert)
+		if err != nil {
+			return fmt.Errorf("Thermo checkOutput: setting %s again failed:\n%w", checked.name, err)
+		}
+	}
+	th.lastWrite = time.Now()
+
+	return nil
+}
+
+func (th *Thermo) CheckIfAlarm() uint {
+	if th.Alarm {
+		return 1
+	}
+
+	return 0
+}
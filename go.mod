@@ -3,10 +3,12 @@ module github.com/hubertat/owkit
 go 1.21
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/goburrow/modbus v0.1.0
 	github.com/hubertat/servicemaker v0.1.2
 	github.com/influxdata/influxdb-client-go/v2 v2.2.3
 	github.com/stianeikeland/go-rpio v4.2.0+incompatible
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
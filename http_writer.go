@@ -17,6 +17,8 @@ type HttpWriter struct {
 
 	ForceUseId		bool		`json:",omitempty"`
 	IntMultiFactor	int
+	// SendRaw adds <name>-raw param with raw readout of calibrated sensors (GET only)
+	SendRaw			bool		`json:",omitempty"`
 }
 
 func (hw *HttpWriter) Send(slaves []*OwSlave) error {
@@ -74,6 +76,9 @@ func (hw *HttpWriter) getSlavesQuery(slaves []*OwSlave) (query url.Values) {
 
 	for _, slv := range slaves {
 		query.Add(slv.Name, fmt.Sprintf("%.0f", math.Round(slv.Value * math.Pow10(hw.IntMultiFactor))))	
+		if hw.SendRaw && slv.Calibration != nil {
+			query.Add(slv.Name + "-raw", fmt.Sprintf("%.0f", math.Round(slv.Raw * math.Pow10(hw.IntMultiFactor))))
+		}
 	}
 
 	return
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
@@ -52,17 +54,12 @@ func (ifw *InfluxWriter) Send(slaves []*OwSlave) error {
 		tags := getTagMap(append(ifw.Tags, ifw.getIdTag(slave)))
 		slavePoint = influxdb2.NewPoint(ifw.Measurment,
 			tags,
-			map[string]interface{}{"temperature": slave.Value},
+			ifw.getSlaveFields(slave),
 			time.Now())
 		if slave.Thermostat != nil {
 			thermoPoint = influxdb2.NewPoint(ifw.Measurment,
 				tags,
-				map[string]interface{}{
-					"setpoint": slave.Thermostat.Setpoint,
-					"real-sp":  slave.Thermostat.GetSetpoint(),
-					"state":    slave.Thermostat.CheckIfOn(),
-					"heatup":   slave.Thermostat.CheckIfHeatUp(),
-				},
+				ifw.getThermoFields(slave.Thermostat),
 				time.Now())
 			err = writeAPI.WritePoint(context.Background(), thermoPoint)
 			if err != nil {
@@ -104,6 +101,25 @@ func (ifw *InfluxWriter) SendWithInflux1(slaves []*OwSlave) error {
 	return nil
 }
 
+// getSlaveFields returns sensor fields, temperature is written only if last read was successful.
+func (ifw *InfluxWriter) getSlaveFields(slave *OwSlave) (fields map[string]interface{}) {
+	fields = map[string]interface{}{
+		"status":   slave.Status,
+		"failures": slave.Failures,
+	}
+	if slave.IsOk() {
+		fields["temperature"] = slave.Value
+	}
+	if slave.Calibration != nil && !slave.LastGood.IsZero() {
+		fields["raw"] = slave.Raw
+	}
+	if !slave.LastGood.IsZero() {
+		fields["age"] = slave.Age().Seconds()
+	}
+
+	return
+}
+
 func (ifw *InfluxWriter) getIdTag(slave *OwSlave) (idTag Tag) {
 	idTag = Tag{Name: "id"}
 	if len(slave.Name) > 0 {
@@ -114,32 +130,75 @@ func (ifw *InfluxWriter) getIdTag(slave *OwSlave) (idTag Tag) {
 	return
 }
 
-func (ifw *InfluxWriter) GetLine(slave *OwSlave) (line string) {
-	line = ifw.Measurment
+// getBaseline returns measurement with tags (line protocol) for sensor.
+func (ifw *InfluxWriter) getBaseline(slave *OwSlave) (baseline string) {
+	baseline = ifw.Measurment
 
 	tags := append(ifw.Tags, ifw.getIdTag(slave))
-
 	for _, tag := range tags {
-		line += fmt.Sprintf(",%s=%s", tag.Name, tag.Value)
+		baseline += fmt.Sprintf(",%s=%s", tag.Name, tag.Value)
 	}
 
-	line += fmt.Sprintf(" temperature=%f\n", slave.Value)
-
 	return
 }
 
-func (ifw *InfluxWriter) GetThermoLines(thermo *Thermo) (line string) {
-	baseline := ifw.Measurment
-
-	tags := append(ifw.Tags, ifw.getIdTag(thermo.Sensor))
-	for _, tag := range tags {
-		baseline += fmt.Sprintf(",%s=%s", tag.Name, tag.Value)
+// formatFields returns fields in line protocol (sorted by name), uint flags (state, heatup, alarm) are
+// written as floats, like in previous versions.
+func formatFields(values map[string]interface{}) string {
+	var fields []string
+	for name, value := range values {
+		switch v := value.(type) {
+		case string:
+			fields = append(fields, fmt.Sprintf("%s=%q", name, v))
+		case int:
+			fields = append(fields, fmt.Sprintf("%s=%di", name, v))
+		case uint:
+			fields = append(fields, fmt.Sprintf("%s=%d", name, v))
+		default:
+			fields = append(fields, fmt.Sprintf("%s=%f", name, v))
+		}
 	}
+	sort.Strings(fields)
+
+	return strings.Join(fields, ",")
+}
 
-	line = baseline + fmt.Sprintf(" setpoint=%f\n", thermo.Setpoint)
-	line += baseline + fmt.Sprintf(" real-sp=%f\n", thermo.GetSetpoint())
-	line += baseline + fmt.Sprintf(" state=%v\n", thermo.CheckIfOn())
-	line += baseline + fmt.Sprintf(" heatup=%v\n", thermo.CheckIfHeatUp())
+func (ifw *InfluxWriter) GetLine(slave *OwSlave) (line string) {
+	return ifw.getBaseline(slave) + " " + formatFields(ifw.getSlaveFields(slave)) + "\n"
+}
+
+func (ifw *InfluxWriter) getThermoFields(thermo *Thermo) (fields map[string]interface{}) {
+	fields = map[string]interface{}{
+		"setpoint": thermo.Setpoint,
+		"real-sp":  thermo.GetSetpoint(),
+		"state":    thermo.CheckIfOn(),
+		"heatup":   thermo.CheckIfHeatUp(),
+		"lockout":  thermo.LockoutSeconds,
+		"alarm":    thermo.CheckIfAlarm(),
+		"mismatch": thermo.Mismatches,
+	}
+	if thermo.Aggregate != nil {
+		fields["aggregate"] = thermo.Aggregate.Value
+		fields["aggregate-used"] = thermo.Aggregate.Used
+	}
+	if len(thermo.Stages) > 0 {
+		fields["stages"] = thermo.getEngaged()
+		for ix, stage := range thermo.Stages {
+			fields[fmt.Sprintf("stage%d-runtime", ix+1)] = stage.RuntimeSeconds
+			fields[fmt.Sprintf("stage%d-starts", ix+1)] = stage.Starts
+		}
+	}
+	if thermo.Control == ControlPid {
+		fields["pid-p"] = thermo.Pid.P
+		fields["pid-i"] = thermo.Pid.I
+		fields["pid-d"] = thermo.Pid.D
+		fields["pid-out"] = thermo.Pid.Output
+	}
 
 	return
 }
+
+// GetThermoLines returns thermostat fields (see getThermoFields) in line protocol.
+func (ifw *InfluxWriter) GetThermoLines(thermo *Thermo) (line string) {
+	return ifw.getBaseline(thermo.Sensor) + " " + formatFields(ifw.getThermoFields(thermo)) + "\n"
+}
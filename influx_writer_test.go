@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetThermoLines(t *testing.T) {
+	ifw := &InfluxWriter{Measurment: "temp", Tags: []Tag{{Name: "site", Value: "home"}}}
+	th := &Thermo{Setpoint: 21.5, IsOn: true, Alarm: true, Mismatches: 3, LockoutSeconds: 10, Sensor: &OwSlave{Name: "tank"}}
+
+	line := ifw.GetThermoLines(th)
+	expected := `temp,site=home,id=tank alarm=1,heatup=0,lockout=10.000000,mismatch=3i,real-sp=21.500000,setpoint=21.500000,state=1` + "\n"
+	if line != expected {
+		t.Errorf("unexpected thermostat line:\n%s\nexpected:\n%s", line, expected)
+	}
+}
+
+func TestGetThermoLinesStages(t *testing.T) {
+	ifw := &InfluxWriter{Measurment: "temp"}
+	th := &Thermo{Setpoint: 60, IsOn: true, Sensor: &OwSlave{Name: "boiler"}, Stages: []*Stage{
+		{Gpio: 5, IsOn: true, RuntimeSeconds: 3600, Starts: 4},
+		{Gpio: 6, RuntimeSeconds: 1800, Starts: 2},
+	}}
+
+	line := ifw.GetThermoLines(th)
+	for _, field := range []string{"stages=1i", "stage1-runtime=3600.000000", "stage1-starts=4i", "stage2-runtime=1800.000000", "stage2-starts=2i"} {
+		if !strings.Contains(line, field) {
+			t.Errorf("expected %s in thermostat line: %s", field, line)
+		}
+	}
+}
+
+func TestGetThermoLinesAggregate(t *testing.T) {
+	ifw := &InfluxWriter{Measurment: "temp", UseInflux1: true}
+	th := &Thermo{Setpoint: 50, Sensor: &OwSlave{Name: "tank"}, Aggregate: &Aggregate{Sensors: []string{"top", "bottom"}, Value: 48.25, Used: 2}}
+
+	line := ifw.GetThermoLines(th)
+	for _, field := range []string{"aggregate=48.250000", "aggregate-used=2i"} {
+		if !strings.Contains(line, field) {
+			t.Errorf("expected %s in thermostat line: %s", field, line)
+		}
+	}
+}
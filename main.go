@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/hubertat/servicemaker"
@@ -28,6 +30,8 @@ var owkitService = servicemaker.ServiceMaker{
 	ExampleConfig: `{
 		"Debug": true,
 		"RefreshSeconds": 10,
+		"ReadRetries": 1,
+		"StateFile": "/srv/owkit/state.json",
 		"Sensors": [{
 			"Name": "sensor-name",
 			"Id": 123456789,
@@ -35,7 +39,13 @@ var owkitService = servicemaker.ServiceMaker{
 				"Gpio": 21,
 				"Hysteresis": 0.8,
 				"Setpoint": 38,
-				"HeatUp": 8
+				"HeatUp": 8,
+				"ShutdownState": "off",
+				"Failsafe": {
+					"Mode": "off",
+					"AfterFailures": 3,
+					"MaxAgeSeconds": 300
+				}
 			}
 		}],
 		"LogInflux": {
@@ -49,7 +59,9 @@ var owkitService = servicemaker.ServiceMaker{
 		},
 		"Server": {
 			"Port": 8080,
-			"IntMultiFactor": 2
+			"IntMultiFactor": 2,
+			"User": "admin",
+			"Password": "change-me"
 		},
 		"OffPeak": {
 			"Url": "http://localhost:1234/offpeak"
@@ -62,8 +74,47 @@ var owkitService = servicemaker.ServiceMaker{
 	}`,
 }
 
+var flagConfig = flag.String("config", "", "Config file path (json, yaml or toml)")
+
+// getExplicitConfigPath returns config path given with -config flag or OWKIT_CONFIG env variable.
+func getExplicitConfigPath() string {
+	if len(*flagConfig) > 0 {
+		return *flagConfig
+	}
+	return os.Getenv("OWKIT_CONFIG")
+}
+
+// findConfigPath returns config path: explicitly given or first existing of default paths.
+func findConfigPath() (path string, err error) {
+	explicit := getExplicitConfigPath()
+	if len(explicit) > 0 {
+		if !isCorrectFile(explicit) {
+			return "", fmt.Errorf("Config file (%s) not found!", explicit)
+		}
+		return explicit, nil
+	}
+
+	var confPaths []string
+	for _, base := range []string{"./config", "/etc/owkit"} {
+		for _, ext := range []string{".json", ".yaml", ".yml", ".toml"} {
+			confPaths = append(confPaths, base+ext)
+		}
+	}
+	for _, p := range confPaths {
+		if isCorrectFile(p) {
+			return p, nil
+		}
+	}
+
+	return "", fmt.Errorf("Config file not found!\n(looking in: %v)", confPaths)
+}
+
 func main() {
 	flagInstall := flag.Bool("install", false, "Install service in os")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: owkit [flags] [command]\n%sflags:\n", commandsUsage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	if *flagInstall {
 		err := owkitService.InstallService()
@@ -75,19 +126,30 @@ func main() {
 		}
 	}
 
-	var err error
-	var path string
+	switch flag.Arg(0) {
+	case "", "run":
+	case "validate":
+		os.Exit(runValidate(flag.Args()[1:]))
+	case "scan":
+		os.Exit(runScan(flag.Args()[1:]))
+	case "read":
+		os.Exit(runRead(flag.Args()[1:]))
+	case "relay":
+		os.Exit(runRelay(flag.Args()[1:]))
+	case "print-config":
+		os.Exit(runPrintConfig(flag.Args()[1:]))
+	case "test-writers":
+		os.Exit(runTestWriters(flag.Args()[1:]))
+	default:
+		fmt.Printf("unknown command: %s\n%s", flag.Arg(0), commandsUsage)
+		os.Exit(2)
+	}
 
 	log.Print("owkit started!")
 
-	confPaths := []string{"./config.json", "/etc/owkit.json"}
-	for _, p := range confPaths {
-		if isCorrectFile(p) {
-			path = p
-		}
-	}
-	if path == "" {
-		log.Fatalf("Config file not found!\n(looking in: %v)", confPaths)
+	path, err := findConfigPath()
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	wires := OwSet{}
@@ -100,6 +162,11 @@ func main() {
 		log.Println("Debugging is enabled!")
 	}
 
+	err = wires.LoadState()
+	if err != nil {
+		log.Printf("ERROR loading runtime state, using config values:\n%v", err)
+	}
+
 	err = wires.InitSlaves()
 	if err != nil {
 		log.Fatal(err)
@@ -113,7 +180,27 @@ func main() {
 		wires.Server.Start()
 	}
 
-	for {
-		time.Sleep(10 * time.Second)
+	if wires.WatchConfig {
+		log.Print("watching config file for changes..")
+		go wires.watchConfigFile(5 * time.Second)
 	}
+
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			log.Print("SIGHUP received, reloading config..")
+			err := wires.Reload()
+			if err != nil {
+				log.Printf("ERROR | config reload:\n%v", err)
+			}
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-stop
+	log.Printf("%v received", sig)
+
+	wires.Shutdown(10 * time.Second)
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	ModeHeat = "heat"
+	ModeCool = "cool"
+	ModeAuto = "auto"
+
+	ActionIdle    = "idle"
+	ActionHeating = "heating"
+	ActionCooling = "cooling"
+)
+
+// InitMode checks mode settings and sets defaults.
+func (th *Thermo) InitMode() error {
+	switch th.Mode {
+	case "":
+		th.Mode = ModeHeat
+	case ModeHeat, ModeCool:
+	case ModeAuto:
+		if th.Control == ControlPid {
+			return fmt.Errorf("Thermo InitMode: pid control is not supported in auto mode")
+		}
+		if th.Deadband == 0 {
+			th.Deadband = 2
+		}
+		if th.CoolSetpoint == 0 {
+			th.CoolSetpoint = th.Setpoint + th.Deadband
+		}
+		if th.CoolSetpoint < th.Setpoint+th.Deadband {
+			return fmt.Errorf("Thermo InitMode: CoolSetpoint (%v) should be at least Deadband (%v) above Setpoint (%v)", th.CoolSetpoint, th.Deadband, th.Setpoint)
+		}
+	default:
+		return fmt.Errorf("Thermo InitMode: unknown mode (%s)", th.Mode)
+	}
+
+	return nil
+}
+
+// SetMode changes thermostat mode, output is switched off to start in new mode from idle.
+func (th *Thermo) SetMode(mode string) error {
+	previous := th.Mode
+	th.Mode = mode
+	err := th.InitMode()
+	if err != nil {
+		th.Mode = previous
+		return err
+	}
+	if previous != th.Mode && th.IsOn {
+		return th.Set(false)
+	}
+
+	return nil
+}
+
+// runHysteresis returns output state required by on/off (hysteresis) control in current mode.
+func (th *Thermo) runHysteresis() (bool, error) {
+	value := th.getInput().Value
+
+	switch th.Mode {
+	case ModeCool:
+		if th.IsOn {
+			return value >= th.GetSetpoint()-th.Hysteresis, nil
+		}
+		return value > th.GetSetpoint()+th.Hysteresis, nil
+	case ModeAuto:
+		return th.runAuto()
+	default:
+		if th.IsOn {
+			return value <= th.GetSetpoint()+th.Hysteresis, nil
+		}
+		return value < th.GetSetpoint()-th.Hysteresis, nil
+	}
+}
+
+// runAuto returns output state in auto mode, when heating or cooling is needed changeover output
+// is set before output is switched on (changeover is never switched while output is on).
+func (th *Thermo) runAuto() (bool, error) {
+	value := th.getInput().Value
+	heatSp, coolSp := th.GetSetpoint(), th.GetCoolSetpoint()
+
+	if th.IsOn {
+		switch th.changeover {
+		case ActionHeating:
+			return value <= heatSp+th.Hysteresis, nil
+		case ActionCooling:
+			return value >= coolSp-th.Hysteresis, nil
+		default:
+			// unknown changeover state (e.g. after start), switching off first
+			return false, nil
+		}
+	}
+
+	var demand string
+	switch {
+	case value < heatSp-th.Hysteresis:
+		demand = ActionHeating
+	case value > coolSp+th.Hysteresis:
+		demand = ActionCooling
+	default:
+		return false, nil
+	}
+
+	if demand != th.changeover {
+		err := th.setChangeover(demand)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// setChangeover sets ChangeoverGpio (if configured): active in cooling, inactive in heating.
+func (th *Thermo) setChangeover(action string) error {
+	log.Printf("Thermo setChangeover: (%s) changing over to %s", th.Sensor.Name, action)
+	th.changeover = action
+	if th.ChangeoverGpio == 0 {
+		return nil
+	}
+
+	out, err := getOutput(th.Output, th.ChangeoverGpio)
+	if err != nil {
+		return fmt.Errorf("Thermo setChangeover: output failed:\n%w", err)
+	}
+
+	active := action == ActionCooling
+	err = out.Set(active != th.Invert)
+	if err != nil {
+		return fmt.Errorf("Thermo setChangeover: switching output failed:\n%w", err)
+	}
+
+	return nil
+}
+
+// updateAction sets Action according to output state and mode.
+func (th *Thermo) updateAction() {
+	switch {
+	case !th.IsOn:
+		th.Action = ActionIdle
+	case th.Mode == ModeCool:
+		th.Action = ActionCooling
+	case th.Mode == ModeAuto && th.changeover == ActionCooling:
+		th.Action = ActionCooling
+	default:
+		th.Action = ActionHeating
+	}
+}
+
+// GetCoolSetpoint returns effective cooling setpoint in auto mode.
+func (th *Thermo) GetCoolSetpoint() (setpoint float64) {
+	setpoint = th.CoolSetpoint
+
+	if th.HeatUpMode {
+		setpoint -= th.HeatUp
+		if th.Min != 0 && setpoint < th.Min {
+			setpoint = th.Min
+		}
+	}
+	if heatSp := th.GetSetpoint(); setpoint < heatSp+th.Deadband {
+		setpoint = heatSp + th.Deadband
+	}
+	return
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/stianeikeland/go-rpio"
+)
+
+const (
+	OutputRpio  = "rpio"
+	OutputGpiod = "gpiod"
+	OutputFake  = "fake"
+
+	OutputShelly  = "shelly"
+	OutputShelly2 = "shelly2"
+	OutputTasmota = "tasmota"
+	OutputModbus  = "modbus"
+)
+
+// Input is a gpio input (e.g. contactor auxiliary contact), state true means it is active (low,
+// inputs have pull up).
+type Input interface {
+	Get() (bool, error)
+	Close() error
+}
+
+// Output is a relay switched by thermostat, state true means relay is energized (gpio outputs
+// are active low). Thermo Invert is applied on top of it.
+type Output interface {
+	Input
+	Set(state bool) error
+}
+
+// OutputConfig selects output driver of thermostat: rpio (default, /dev/gpiomem on Broadcom SoCs),
+// gpiod (gpio character device, Chip defaults to /dev/gpiochip0), fake (in memory, for tests) or
+// remote relay: shelly (Gen1), shelly2 (Gen2 rpc), tasmota (Host is url) and modbus (Host is
+// host:port, Channel is coil address). Remote relays are verified by reading back.
+type OutputConfig struct {
+	Driver string
+	Chip   string `json:",omitempty"`
+
+	Host           string `json:",omitempty"`
+	Channel        int    `json:",omitempty"`
+	SlaveId        int    `json:",omitempty"`
+	User           string `json:",omitempty"`
+	Password       string `json:",omitempty"`
+	TimeoutSeconds int    `json:",omitempty"`
+	Retries        int    `json:",omitempty"`
+}
+
+// Init sets defaults and checks driver.
+func (oc *OutputConfig) Init() error {
+	switch oc.Driver {
+	case "":
+		oc.Driver = OutputRpio
+	case OutputRpio, OutputFake:
+	case OutputGpiod:
+		if len(oc.Chip) == 0 {
+			oc.Chip = "/dev/gpiochip0"
+		}
+		if !strings.HasPrefix(oc.Chip, "/") {
+			oc.Chip = "/dev/" + oc.Chip
+		}
+	case OutputShelly, OutputShelly2, OutputTasmota, OutputModbus:
+		if len(oc.Host) == 0 {
+			return fmt.Errorf("OutputConfig Init: %s driver requires Host", oc.Driver)
+		}
+		if oc.Driver == OutputModbus && oc.SlaveId == 0 {
+			oc.SlaveId = 1
+		}
+		// Shelly Gen2 auth user is always admin
+		if oc.Driver == OutputShelly2 && len(oc.Password) > 0 && len(oc.User) == 0 {
+			oc.User = "admin"
+		}
+		if oc.TimeoutSeconds == 0 {
+			oc.TimeoutSeconds = 3
+		}
+		if oc.Retries == 0 {
+			oc.Retries = 2
+		}
+	default:
+		return fmt.Errorf("OutputConfig Init: unknown driver (%s)", oc.Driver)
+	}
+
+	return nil
+}
+
+// IsGpio returns true for drivers switching local gpio (Thermo Gpio is used).
+func (oc *OutputConfig) IsGpio() bool {
+	return oc == nil || oc.Driver == OutputRpio || oc.Driver == OutputGpiod || oc.Driver == OutputFake
+}
+
+// getKey returns key identifying single output (relay).
+func (oc *OutputConfig) getKey(gpio int) string {
+	if oc.IsGpio() {
+		return fmt.Sprintf("%s:%s:%d", oc.Driver, oc.Chip, gpio)
+	}
+
+	return fmt.Sprintf("%s:%s:%d:%d", oc.Driver, oc.Host, oc.SlaveId, oc.Channel)
+}
+
+// outputs are opened once and shared by thermostats (also between config reloads), so gpio
+// lines are held for process lifetime.
+var outputs = struct {
+	sync.Mutex
+	open map[string]Input
+}{open: map[string]Input{}}
+
+// getOutput returns opened output for gpio (or remote relay), opening it on first use.
+func getOutput(oc *OutputConfig, gpio int) (Output, error) {
+	if oc == nil {
+		oc = &OutputConfig{}
+		oc.Init()
+	}
+	key := oc.getKey(gpio)
+
+	outputs.Lock()
+	defer outputs.Unlock()
+
+	opened, found := outputs.open[key]
+	if found {
+		out, ok := opened.(Output)
+		if !ok {
+			return nil, fmt.Errorf("getOutput: %s is opened as input", key)
+		}
+		return out, nil
+	}
+
+	var out Output
+	var err error
+	switch oc.Driver {
+	case OutputRpio:
+		out = &rpioOutput{Gpio: gpio}
+	case OutputGpiod:
+		out, err = openGpiodOutput(oc.Chip, gpio)
+	case OutputFake:
+		out = &fakeOutput{Name: key}
+	default:
+		out, err = newRelayOutput(oc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getOutput: opening %s failed:\n%w", key, err)
+	}
+	outputs.open[key] = out
+
+	return out, nil
+}
+
+// getInput returns opened gpio input, opening it on first use. Fake input reads state of fake
+// output with the same gpio.
+func getInput(oc *OutputConfig, gpio int) (Input, error) {
+	if oc.Driver == OutputFake {
+		return getOutput(oc, gpio)
+	}
+	key := "input:" + oc.getKey(gpio)
+
+	outputs.Lock()
+	defer outputs.Unlock()
+
+	in, found := outputs.open[key]
+	if found {
+		return in, nil
+	}
+
+	var err error
+	switch oc.Driver {
+	case OutputRpio:
+		in = &rpioInput{Gpio: gpio}
+	case OutputGpiod:
+		in, err = openGpiodInput(oc.Chip, gpio)
+	default:
+		err = fmt.Errorf("driver %s doesn't support inputs", oc.Driver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getInput: opening %s failed:\n%w", key, err)
+	}
+	outputs.open[key] = in
+
+	return in, nil
+}
+
+// closeOutputs closes all opened outputs and inputs (relays keep their state).
+func closeOutputs() {
+	outputs.Lock()
+	defer outputs.Unlock()
+
+	for key, out := range outputs.open {
+		err := out.Close()
+		if err != nil {
+			log.Printf("ERROR | closeOutputs | %s:\n%v", key, err)
+		}
+		delete(outputs.open, key)
+	}
+}
+
+// rpioOutput drives gpio through go-rpio (memory mapped /dev/gpiomem), mapping is opened on first use.
+type rpioOutput struct {
+	Gpio int
+}
+
+var rpioOpened struct {
+	sync.Mutex
+	opened bool
+}
+
+// openRpio opens rpio memory mapping, if not opened yet.
+func openRpio() error {
+	rpioOpened.Lock()
+	defer rpioOpened.Unlock()
+
+	if !rpioOpened.opened {
+		err := rpio.Open()
+		if err != nil {
+			return fmt.Errorf("opening rpio failed:\n%w", err)
+		}
+		rpioOpened.opened = true
+	}
+
+	return nil
+}
+
+func closeRpio() error {
+	rpioOpened.Lock()
+	defer rpioOpened.Unlock()
+
+	if !rpioOpened.opened {
+		return nil
+	}
+	rpioOpened.opened = false
+
+	return rpio.Close()
+}
+
+func (ro *rpioOutput) pin() (rpio.Pin, error) {
+	err := openRpio()
+	if err != nil {
+		return 0, fmt.Errorf("rpioOutput: %w", err)
+	}
+
+	pin := rpio.Pin(ro.Gpio)
+	pin.Output()
+
+	return pin, nil
+}
+
+func (ro *rpioOutput) Get() (bool, error) {
+	pin, err := ro.pin()
+	if err != nil {
+		return false, err
+	}
+
+	return pin.Read() == rpio.Low, nil
+}
+
+func (ro *rpioOutput) Set(state bool) error {
+	pin, err := ro.pin()
+	if err != nil {
+		return err
+	}
+
+	if state {
+		pin.Low()
+	} else {
+		pin.High()
+	}
+
+	return nil
+}
+
+func (ro *rpioOutput) Close() error {
+	return closeRpio()
+}
+
+// rpioInput reads gpio through go-rpio, with pull up.
+type rpioInput struct {
+	Gpio int
+}
+
+func (ri *rpioInput) Get() (bool, error) {
+	err := openRpio()
+	if err != nil {
+		return false, fmt.Errorf("rpioInput: %w", err)
+	}
+
+	pin := rpio.Pin(ri.Gpio)
+	pin.Input()
+	pin.PullUp()
+
+	return pin.Read() == rpio.Low, nil
+}
+
+func (ri *rpioInput) Close() error {
+	return closeRpio()
+}
+
+// fakeOutput keeps state in memory only.
+type fakeOutput struct {
+	Name  string
+	State bool
+}
+
+func (fo *fakeOutput) Get() (bool, error) {
+	return fo.State, nil
+}
+
+func (fo *fakeOutput) Set(state bool) error {
+	log.Printf("fakeOutput: (%s) set to [%v]", fo.Name, state)
+	fo.State = state
+
+	return nil
+}
+
+func (fo *fakeOutput) Close() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// gpio character device uapi v2 (linux/gpio.h)
+const (
+	gpioV2GetLine      = 0xC250B407 // _IOWR(0xB4, 0x07, struct gpio_v2_line_request)
+	gpioV2SetConfig    = 0xC110B40D // _IOWR(0xB4, 0x0D, struct gpio_v2_line_config)
+	gpioV2GetValues    = 0xC010B40E // _IOWR(0xB4, 0x0E, struct gpio_v2_line_values)
+	gpioV2SetValues    = 0xC010B40F // _IOWR(0xB4, 0x0F, struct gpio_v2_line_values)
+	gpioV2FlagInput    = 1 << 2
+	gpioV2FlagOutput   = 1 << 3
+	gpioV2FlagPullUp   = 1 << 8
+	gpioV2AttrOutValue = 2
+
+	gpioV2RequestSize = 592
+	gpioV2ConfigSize  = 272
+	gpioV2ValuesSize  = 16
+
+	gpioV2ConfigOffset = 288 // offsets[64] + consumer[32]
+	gpioV2LinesOffset  = 560
+	gpioV2FdOffset     = 588
+)
+
+// gpiodOutput holds single line requested from gpio character device (e.g. /dev/gpiochip0).
+type gpiodOutput struct {
+	Chip string
+	Line int
+
+	lock sync.Mutex
+	fd   int
+}
+
+func ioctl(fd int, request uintptr, data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(unsafe.Pointer(&data[0])))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// putOutputConfig fills gpio_v2_line_config (at buf start): output direction with given value.
+func putOutputConfig(buf []byte, level bool) {
+	binary.NativeEndian.PutUint64(buf[0:], gpioV2FlagOutput)
+	binary.NativeEndian.PutUint32(buf[8:], 1)
+	// attrs[0]: id, padding, values, mask
+	binary.NativeEndian.PutUint32(buf[32:], gpioV2AttrOutValue)
+	if level {
+		binary.NativeEndian.PutUint64(buf[40:], 1)
+	}
+	binary.NativeEndian.PutUint64(buf[48:], 1)
+}
+
+// requestGpiodLine requests single line from chip with given flags (0 leaves line as it is).
+func requestGpiodLine(chip string, line int, flags uint64) (fd int, err error) {
+	chipFile, err := os.Open(chip)
+	if err != nil {
+		return 0, fmt.Errorf("requestGpiodLine: opening chip failed:\n%w", err)
+	}
+	defer chipFile.Close()
+
+	req := make([]byte, gpioV2RequestSize)
+	binary.NativeEndian.PutUint32(req[0:], uint32(line))
+	copy(req[256:], "owkit")
+	binary.NativeEndian.PutUint64(req[gpioV2ConfigOffset:], flags)
+	binary.NativeEndian.PutUint32(req[gpioV2LinesOffset:], 1)
+	err = ioctl(int(chipFile.Fd()), gpioV2GetLine, req)
+	if err != nil {
+		return 0, fmt.Errorf("requestGpiodLine: requesting line %d of %s failed:\n%w", line, chip, err)
+	}
+
+	return int(int32(binary.NativeEndian.Uint32(req[gpioV2FdOffset:]))), nil
+}
+
+// openGpiodOutput requests line without changing it, reads its level and switches it to output
+// with the same level, so relay is not toggled on start.
+func openGpiodOutput(chip string, line int) (Output, error) {
+	fd, err := requestGpiodLine(chip, line, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &gpiodOutput{Chip: chip, Line: line, fd: fd}
+	level, err := out.getLevel()
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	config := make([]byte, gpioV2ConfigSize)
+	putOutputConfig(config, level)
+	err = ioctl(out.fd, gpioV2SetConfig, config)
+	if err != nil {
+		out.Close()
+		return nil, fmt.Errorf("openGpiodOutput: setting line %d as output failed:\n%w", line, err)
+	}
+
+	return out, nil
+}
+
+func (gout *gpiodOutput) getLevel() (bool, error) {
+	values := make([]byte, gpioV2ValuesSize)
+	binary.NativeEndian.PutUint64(values[8:], 1)
+	err := ioctl(gout.fd, gpioV2GetValues, values)
+	if err != nil {
+		return false, fmt.Errorf("gpiodOutput: reading line %d failed:\n%w", gout.Line, err)
+	}
+
+	return binary.NativeEndian.Uint64(values[0:])&1 == 1, nil
+}
+
+func (gout *gpiodOutput) Get() (bool, error) {
+	gout.lock.Lock()
+	defer gout.lock.Unlock()
+
+	level, err := gout.getLevel()
+
+	return !level, err
+}
+
+func (gout *gpiodOutput) Set(state bool) error {
+	gout.lock.Lock()
+	defer gout.lock.Unlock()
+
+	values := make([]byte, gpioV2ValuesSize)
+	if !state {
+		binary.NativeEndian.PutUint64(values[0:], 1)
+	}
+	binary.NativeEndian.PutUint64(values[8:], 1)
+	err := ioctl(gout.fd, gpioV2SetValues, values)
+	if err != nil {
+		return fmt.Errorf("gpiodOutput: setting line %d failed:\n%w", gout.Line, err)
+	}
+
+	return nil
+}
+
+func (gout *gpiodOutput) Close() error {
+	gout.lock.Lock()
+	defer gout.lock.Unlock()
+
+	if gout.fd <= 0 {
+		return nil
+	}
+	err := syscall.Close(gout.fd)
+	gout.fd = 0
+
+	return err
+}
+
+// gpiodInput is input line (with pull up) requested from gpio character device.
+type gpiodInput struct {
+	line *gpiodOutput
+}
+
+func (gin *gpiodInput) Get() (bool, error) {
+	return gin.line.Get()
+}
+
+func (gin *gpiodInput) Close() error {
+	return gin.line.Close()
+}
+
+func openGpiodInput(chip string, line int) (Input, error) {
+	fd, err := requestGpiodLine(chip, line, gpioV2FlagInput|gpioV2FlagPullUp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gpiodInput{line: &gpiodOutput{Chip: chip, Line: line, fd: fd}}, nil
+}
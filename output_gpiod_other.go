@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func openGpiodOutput(chip string, line int) (Output, error) {
+	return nil, fmt.Errorf("openGpiodOutput: gpio character device is supported on linux only")
+}
+
+func openGpiodInput(chip string, line int) (Input, error) {
+	return nil, fmt.Errorf("openGpiodInput: gpio character device is supported on linux only")
+}
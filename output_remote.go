@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// remoteRelay switches relay over network, relayOutput adds read-back verification and retries.
+type remoteRelay interface {
+	Get() (bool, error)
+	Set(state bool) error
+}
+
+// relayOutput sets remote relay and reads it back, retrying (Retries times) when request fails
+// or relay reports other state than requested.
+type relayOutput struct {
+	Name    string
+	Retries int
+	Delay   time.Duration
+
+	lock  sync.Mutex
+	relay remoteRelay
+}
+
+func (ro *relayOutput) Get() (state bool, err error) {
+	ro.lock.Lock()
+	defer ro.lock.Unlock()
+
+	for try := 0; try <= ro.Retries; try++ {
+		if try > 0 {
+			time.Sleep(ro.Delay)
+		}
+		state, err = ro.relay.Get()
+		if err == nil {
+			return
+		}
+	}
+
+	return false, fmt.Errorf("relayOutput Get: (%s) reading relay failed:\n%w", ro.Name, err)
+}
+
+func (ro *relayOutput) Set(state bool) (err error) {
+	ro.lock.Lock()
+	defer ro.lock.Unlock()
+
+	for try := 0; try <= ro.Retries; try++ {
+		if try > 0 {
+			log.Printf("relayOutput Set: (%s) retrying (%d/%d) after: %v", ro.Name, try, ro.Retries, err)
+			time.Sleep(ro.Delay)
+		}
+		err = ro.relay.Set(state)
+		if err != nil {
+			continue
+		}
+		var actual bool
+		actual, err = ro.relay.Get()
+		if err != nil {
+			continue
+		}
+		if actual != state {
+			err = fmt.Errorf("relay reports [%v] after setting [%v]", actual, state)
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("relayOutput Set: (%s) switching relay to [%v] failed:\n%w", ro.Name, state, err)
+}
+
+func (ro *relayOutput) Close() error {
+	return nil
+}
+
+// newRelayOutput creates remote relay output for Shelly, Tasmota or Modbus driver.
+func newRelayOutput(oc *OutputConfig) (Output, error) {
+	timeout := time.Duration(oc.TimeoutSeconds) * time.Second
+	client := &http.Client{Timeout: timeout}
+
+	var relay remoteRelay
+	switch oc.Driver {
+	case OutputShelly:
+		relay = &shellyRelay{Host: oc.Host, Channel: oc.Channel, User: oc.User, Password: oc.Password, client: client}
+	case OutputShelly2:
+		shelly2 := &shelly2Relay{Host: oc.Host, Channel: oc.Channel, client: client}
+		if len(oc.Password) > 0 {
+			shelly2.auth = &digestAuth{User: oc.User, Password: oc.Password}
+		}
+		relay = shelly2
+	case OutputTasmota:
+		relay = &tasmotaRelay{Host: oc.Host, Channel: oc.Channel, User: oc.User, Password: oc.Password, client: client}
+	case OutputModbus:
+		relay = &modbusRelay{Host: oc.Host, Coil: oc.Channel, SlaveId: oc.SlaveId, Timeout: timeout}
+	default:
+		return nil, fmt.Errorf("newRelayOutput: unknown driver (%s)", oc.Driver)
+	}
+
+	return &relayOutput{
+		Name:    fmt.Sprintf("%s %s/%d", oc.Driver, oc.Host, oc.Channel),
+		Retries: oc.Retries,
+		Delay:   500 * time.Millisecond,
+		relay:   relay,
+	}, nil
+}
+
+// getRelayJson sends GET request and decodes json response into result.
+func getRelayJson(client *http.Client, req *http.Request, result interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	return decodeRelayJson(res, result)
+}
+
+// decodeRelayJson reads and closes response body, decodes json into result.
+func decodeRelayJson(res *http.Response, result interface{}) error {
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return fmt.Errorf("bad response (%s): %w", strings.TrimSpace(string(body)), err)
+	}
+
+	return nil
+}
+
+// digestParamPattern matches key=value and key="value" params of WWW-Authenticate header.
+var digestParamPattern = regexp.MustCompile(`(\w+)=("([^"]*)"|[^,\s]*)`)
+
+// digestAuth is http digest authentication (RFC 7616, MD5 or SHA-256) with challenge kept between
+// requests, nonce count is increased on every request.
+type digestAuth struct {
+	User, Password string
+
+	params map[string]string
+	count  int
+}
+
+// setChallenge keeps params of Digest challenge from WWW-Authenticate header.
+func (da *digestAuth) setChallenge(header string) error {
+	if !strings.HasPrefix(strings.ToLower(header), "digest ") {
+		return fmt.Errorf("digestAuth: expected Digest challenge, got (%s)", header)
+	}
+	params := map[string]string{}
+	for _, match := range digestParamPattern.FindAllStringSubmatch(header[len("digest "):], -1) {
+		value := match[2]
+		if strings.HasPrefix(value, `"`) {
+			value = match[3]
+		}
+		params[strings.ToLower(match[1])] = value
+	}
+	if len(params["nonce"]) == 0 {
+		return fmt.Errorf("digestAuth: challenge without nonce (%s)", header)
+	}
+	da.params = params
+	da.count = 0
+
+	return nil
+}
+
+// authorize sets Authorization header of request, it does nothing before first challenge.
+func (da *digestAuth) authorize(req *http.Request) error {
+	if da.params == nil {
+		return nil
+	}
+
+	var hash func(string) string
+	switch strings.ToUpper(da.params["algorithm"]) {
+	case "", "MD5":
+		hash = func(value string) string { return fmt.Sprintf("%x", md5.Sum([]byte(value))) }
+	case "SHA-256":
+		hash = func(value string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(value))) }
+	default:
+		return fmt.Errorf("digestAuth: unsupported algorithm (%s)", da.params["algorithm"])
+	}
+
+	realm, nonce, uri := da.params["realm"], da.params["nonce"], req.URL.RequestURI()
+	ha1 := hash(da.User + ":" + realm + ":" + da.Password)
+	ha2 := hash(req.Method + ":" + uri)
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`, da.User, realm, nonce, uri)
+
+	if len(da.params["qop"]) > 0 {
+		supported := false
+		for _, qop := range strings.Split(da.params["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				supported = true
+			}
+		}
+		if !supported {
+			return fmt.Errorf("digestAuth: unsupported qop (%s)", da.params["qop"])
+		}
+		da.count++
+		cnonce := make([]byte, 8)
+		_, err := rand.Read(cnonce)
+		if err != nil {
+			return err
+		}
+		nc := fmt.Sprintf("%08x", da.count)
+		response := hash(fmt.Sprintf("%s:%s:%s:%x:auth:%s", ha1, nonce, nc, cnonce, ha2))
+		header += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%x", response="%s"`, nc, cnonce, response)
+	} else {
+		header += fmt.Sprintf(`, response="%s"`, hash(ha1+":"+nonce+":"+ha2))
+	}
+	if len(da.params["algorithm"]) > 0 {
+		header += ", algorithm=" + da.params["algorithm"]
+	}
+	if opaque, found := da.params["opaque"]; found {
+		header += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	req.Header.Set("Authorization", header)
+
+	return nil
+}
+
+// shellyRelay is Shelly Gen1 relay (/relay/<channel>), with optional basic auth.
+type shellyRelay struct {
+	Host           string
+	Channel        int
+	User, Password string
+
+	client *http.Client
+}
+
+func (sr *shellyRelay) request(query string) (state bool, err error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/relay/%d%s", strings.TrimSuffix(sr.Host, "/"), sr.Channel, query), nil)
+	if err != nil {
+		return
+	}
+	if len(sr.User) > 0 {
+		req.SetBasicAuth(sr.User, sr.Password)
+	}
+
+	var status struct {
+		IsOn *bool `json:"ison"`
+	}
+	err = getRelayJson(sr.client, req, &status)
+	if err != nil {
+		return
+	}
+	if status.IsOn == nil {
+		return false, fmt.Errorf("shelly response without ison")
+	}
+
+	return *status.IsOn, nil
+}
+
+func (sr *shellyRelay) Get() (bool, error) {
+	return sr.request("")
+}
+
+func (sr *shellyRelay) Set(state bool) error {
+	turn := "off"
+	if state {
+		turn = "on"
+	}
+	_, err := sr.request("?turn=" + turn)
+
+	return err
+}
+
+// shelly2Relay is Shelly Gen2/Gen3 switch component (rpc Switch.Set / Switch.GetStatus), with digest
+// auth when Password is set.
+type shelly2Relay struct {
+	Host    string
+	Channel int
+
+	client *http.Client
+	auth   *digestAuth
+}
+
+func (sr *shelly2Relay) rpc(method string, query string, result interface{}) error {
+	rpcUrl := fmt.Sprintf("%s/rpc/%s?id=%d%s", strings.TrimSuffix(sr.Host, "/"), method, sr.Channel, query)
+	req, err := http.NewRequest("GET", rpcUrl, nil)
+	if err != nil {
+		return err
+	}
+	if sr.auth == nil {
+		return getRelayJson(sr.client, req, result)
+	}
+
+	err = sr.auth.authorize(req)
+	if err != nil {
+		return err
+	}
+	res, err := sr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusUnauthorized {
+		return decodeRelayJson(res, result)
+	}
+
+	// first request or nonce expired, repeat with new challenge
+	res.Body.Close()
+	err = sr.auth.setChallenge(res.Header.Get("WWW-Authenticate"))
+	if err != nil {
+		return err
+	}
+	req, err = http.NewRequest("GET", rpcUrl, nil)
+	if err != nil {
+		return err
+	}
+	err = sr.auth.authorize(req)
+	if err != nil {
+		return err
+	}
+
+	return getRelayJson(sr.client, req, result)
+}
+
+func (sr *shelly2Relay) Get() (bool, error) {
+	var status struct {
+		Output *bool `json:"output"`
+	}
+	err := sr.rpc("Switch.GetStatus", "", &status)
+	if err != nil {
+		return false, err
+	}
+	if status.Output == nil {
+		return false, fmt.Errorf("shelly rpc response without output")
+	}
+
+	return *status.Output, nil
+}
+
+func (sr *shelly2Relay) Set(state bool) error {
+	var result map[string]interface{}
+
+	return sr.rpc("Switch.Set", fmt.Sprintf("&on=%v", state), &result)
+}
+
+// tasmotaRelay is Tasmota relay switched with Power<channel> command (channel 0 is just Power).
+type tasmotaRelay struct {
+	Host           string
+	Channel        int
+	User, Password string
+
+	client *http.Client
+}
+
+func (tr *tasmotaRelay) command(value string) (state bool, err error) {
+	command := "Power"
+	if tr.Channel > 0 {
+		command = fmt.Sprintf("Power%d", tr.Channel)
+	}
+	if len(value) > 0 {
+		command += " " + value
+	}
+	query := url.Values{"cmnd": {command}}
+	if len(tr.User) > 0 {
+		query.Set("user", tr.User)
+		query.Set("password", tr.Password)
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(tr.Host, "/")+"/cm?"+query.Encode(), nil)
+	if err != nil {
+		return
+	}
+
+	var result map[string]interface{}
+	err = getRelayJson(tr.client, req, &result)
+	if err != nil {
+		return
+	}
+	// response key is POWER or POWER<n>, depending on number of relays
+	for key, value := range result {
+		if !strings.HasPrefix(strings.ToUpper(key), "POWER") {
+			continue
+		}
+		if tr.Channel > 1 && strings.ToUpper(key) != fmt.Sprintf("POWER%d", tr.Channel) {
+			continue
+		}
+		return value == "ON", nil
+	}
+
+	return false, fmt.Errorf("tasmota response without power state (%v)", result)
+}
+
+func (tr *tasmotaRelay) Get() (bool, error) {
+	return tr.command("")
+}
+
+func (tr *tasmotaRelay) Set(state bool) error {
+	value := "Off"
+	if state {
+		value = "On"
+	}
+	_, err := tr.command(value)
+
+	return err
+}
+
+// modbusRelay is coil of Modbus TCP relay board.
+type modbusRelay struct {
+	Host    string
+	Coil    int
+	SlaveId int
+	Timeout time.Duration
+}
+
+func (mr *modbusRelay) connect() (*modbus.TCPClientHandler, modbus.Client, error) {
+	handler := modbus.NewTCPClientHandler(mr.Host)
+	handler.Timeout = mr.Timeout
+	handler.SlaveId = byte(mr.SlaveId)
+
+	err := handler.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return handler, modbus.NewClient(handler), nil
+}
+
+func (mr *modbusRelay) Get() (bool, error) {
+	handler, client, err := mr.connect()
+	if err != nil {
+		return false, err
+	}
+	defer handler.Close()
+
+	result, err := client.ReadCoils(uint16(mr.Coil), 1)
+	if err != nil {
+		return false, err
+	}
+	if len(result) == 0 {
+		return false, fmt.Errorf("modbus empty coil readout")
+	}
+
+	return result[0]&1 == 1, nil
+}
+
+func (mr *modbusRelay) Set(state bool) error {
+	handler, client, err := mr.connect()
+	if err != nil {
+		return err
+	}
+	defer handler.Close()
+
+	var value uint16
+	if state {
+		value = 0xFF00
+	}
+	_, err = client.WriteSingleCoil(uint16(mr.Coil), value)
+
+	return err
+}
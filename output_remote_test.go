@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRelays is state of remote relay board stand-in (Shelly, Tasmota or Modbus).
+type fakeRelays struct {
+	lock     sync.Mutex
+	states   map[int]bool
+	stuck    bool // switching is ignored (relay reports old state)
+	fail     int  // number of requests failing before relay responds
+	requests int
+
+	password string // Shelly Gen2 digest auth (user admin, SHA-256)
+	nonce    string
+}
+
+func newFakeRelays() *fakeRelays {
+	return &fakeRelays{states: map[int]bool{}}
+}
+
+// request counts request and returns false when it should fail.
+func (fr *fakeRelays) request() bool {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.requests++
+	if fr.fail > 0 {
+		fr.fail--
+		return false
+	}
+	return true
+}
+
+// configure sets failing requests and stuck relays, returns number of requests so far.
+func (fr *fakeRelays) configure(fail int, stuck bool) int {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fr.fail = fail
+	fr.stuck = stuck
+	return fr.requests
+}
+
+func (fr *fakeRelays) get(channel int) bool {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	return fr.states[channel]
+}
+
+func (fr *fakeRelays) set(channel int, state bool) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	if !fr.stuck {
+		fr.states[channel] = state
+	}
+}
+
+// handleShelly serves Shelly Gen1 /relay/<channel>?turn=on|off, with basic auth admin/s3cret.
+func (fr *fakeRelays) handleShelly(w http.ResponseWriter, r *http.Request) {
+	if !fr.request() {
+		http.Error(w, "busy", http.StatusInternalServerError)
+		return
+	}
+	user, password, _ := r.BasicAuth()
+	if user != "admin" || password != "s3cret" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	channel, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/relay/"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.URL.Query().Get("turn") {
+	case "on":
+		fr.set(channel, true)
+	case "off":
+		fr.set(channel, false)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ison": fr.get(channel), "has_timer": false})
+}
+
+// checkDigest checks Shelly Gen2 digest auth (admin/password, SHA-256, qop auth) with current nonce.
+func (fr *fakeRelays) checkDigest(r *http.Request) bool {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Digest ") {
+		return false
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(header, "Digest "), ", ") {
+		key, value, _ := strings.Cut(param, "=")
+		params[key] = strings.Trim(value, `"`)
+	}
+	hash := func(value string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(value))) }
+	ha1 := hash("admin:shellypro1:" + fr.password)
+	ha2 := hash(r.Method + ":" + r.URL.RequestURI())
+	response := hash(strings.Join([]string{ha1, fr.nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+
+	return params["username"] == "admin" && params["nonce"] == fr.nonce && params["uri"] == r.URL.RequestURI() &&
+		params["qop"] == "auth" && params["algorithm"] == "SHA-256" && params["response"] == response
+}
+
+// handleShelly2 serves Shelly Gen2 rpc Switch.Set and Switch.GetStatus, with digest auth when password is set.
+func (fr *fakeRelays) handleShelly2(w http.ResponseWriter, r *http.Request) {
+	if !fr.request() {
+		http.Error(w, "busy", http.StatusInternalServerError)
+		return
+	}
+	if len(fr.password) > 0 && !fr.checkDigest(r) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest qop="auth", realm="shellypro1", nonce="%s", algorithm=SHA-256`, fr.nonce))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	channel, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/rpc/Switch.Set":
+		was := fr.get(channel)
+		fr.set(channel, r.URL.Query().Get("on") == "true")
+		json.NewEncoder(w).Encode(map[string]interface{}{"was_on": was})
+	case "/rpc/Switch.GetStatus":
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": channel, "source": "http", "output": fr.get(channel)})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleTasmota serves Tasmota /cm?cmnd=Power<n> [On|Off], single relay device responds with POWER key,
+// multi relay device with POWER<n>.
+func (fr *fakeRelays) handleTasmota(single bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !fr.request() {
+			http.Error(w, "busy", http.StatusInternalServerError)
+			return
+		}
+		fields := strings.Fields(r.URL.Query().Get("cmnd"))
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "Power") {
+			json.NewEncoder(w).Encode(map[string]string{"Command": "Unknown"})
+			return
+		}
+		channel := 1
+		if index := strings.TrimPrefix(fields[0], "Power"); len(index) > 0 {
+			channel, _ = strconv.Atoi(index)
+		}
+		if len(fields) > 1 {
+			fr.set(channel, fields[1] == "On")
+		}
+		key := fmt.Sprintf("POWER%d", channel)
+		if single {
+			key = "POWER"
+		}
+		value := "OFF"
+		if fr.get(channel) {
+			value = "ON"
+		}
+		json.NewEncoder(w).Encode(map[string]string{key: value})
+	}
+}
+
+// serveModbus serves Modbus TCP read coils (0x01) and write single coil (0x05) requests.
+func (fr *fakeRelays) serveModbus(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				header := make([]byte, 7)
+				_, err := io.ReadFull(conn, header)
+				if err != nil {
+					return
+				}
+				pdu := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+				_, err = io.ReadFull(conn, pdu)
+				if err != nil {
+					return
+				}
+				if !fr.request() {
+					// no response, client times out
+					return
+				}
+
+				coil := int(binary.BigEndian.Uint16(pdu[1:3]))
+				var response []byte
+				switch pdu[0] {
+				case 0x01:
+					var value byte
+					if fr.get(coil) {
+						value = 1
+					}
+					response = []byte{0x01, 1, value}
+				case 0x05:
+					fr.set(coil, binary.BigEndian.Uint16(pdu[3:5]) == 0xFF00)
+					response = pdu[:5]
+				default:
+					response = []byte{pdu[0] | 0x80, 0x01}
+				}
+				binary.BigEndian.PutUint16(header[4:6], uint16(len(response)+1))
+				conn.Write(append(header, response...))
+			}
+		}()
+	}
+}
+
+// newTestRelayOutput returns relay output for given driver and host, with short retry delay.
+func newTestRelayOutput(t *testing.T, oc *OutputConfig) *relayOutput {
+	t.Helper()
+	err := oc.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oc.TimeoutSeconds = 1
+	out, err := newRelayOutput(oc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := out.(*relayOutput)
+	relay.Delay = time.Millisecond
+
+	return relay
+}
+
+// checkRelay switches relay on and off and checks stand-in state.
+func checkRelay(t *testing.T, relays *fakeRelays, channel int, out Output) {
+	t.Helper()
+	for _, state := range []bool{true, false, true} {
+		err := out.Set(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relays.get(channel) != state {
+			t.Fatalf("relay %d not switched to [%v]", channel, state)
+		}
+		actual, err := out.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != state {
+			t.Fatalf("relay %d reads [%v], expected [%v]", channel, actual, state)
+		}
+	}
+}
+
+func TestShellyRelay(t *testing.T) {
+	relays := newFakeRelays()
+	server := httptest.NewServer(http.HandlerFunc(relays.handleShelly))
+	defer server.Close()
+
+	out := newTestRelayOutput(t, &OutputConfig{Driver: OutputShelly, Host: server.URL, Channel: 1, User: "admin", Password: "s3cret"})
+	checkRelay(t, relays, 1, out)
+
+	out = newTestRelayOutput(t, &OutputConfig{Driver: OutputShelly, Host: server.URL, Channel: 1, User: "admin", Password: "wrong"})
+	err := out.Set(false)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized error, got: %v", err)
+	}
+}
+
+func TestShelly2Relay(t *testing.T) {
+	relays := newFakeRelays()
+	server := httptest.NewServer(http.HandlerFunc(relays.handleShelly2))
+	defer server.Close()
+
+	checkRelay(t, relays, 0, newTestRelayOutput(t, &OutputConfig{Driver: OutputShelly2, Host: server.URL}))
+	checkRelay(t, relays, 2, newTestRelayOutput(t, &OutputConfig{Driver: OutputShelly2, Host: server.URL + "/", Channel: 2}))
+	if relays.get(1) {
+		t.Error("other channel should not be switched")
+	}
+}
+
+func TestShelly2RelayDigestAuth(t *testing.T) {
+	relays := newFakeRelays()
+	relays.password = "s3cret"
+	relays.nonce = "1700000000"
+	server := httptest.NewServer(http.HandlerFunc(relays.handleShelly2))
+	defer server.Close()
+
+	// user defaults to admin
+	out := newTestRelayOutput(t, &OutputConfig{Driver: OutputShelly2, Host: server.URL, Channel: 1, Password: "s3cret"})
+	checkRelay(t, relays, 1, out)
+
+	// challenge is kept, one request per rpc call
+	requests := relays.configure(0, false)
+	_, err := out.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current := relays.configure(0, false); current != requests+1 {
+		t.Errorf("expected single request with kept challenge, got %d", current-requests)
+	}
+
+	// expired nonce, new challenge is used
+	relays.lock.Lock()
+	relays.nonce = "1700000600"
+	relays.lock.Unlock()
+	checkRelay(t, relays, 1, out)
+
+	out = newTestRelayOutput(t, &OutputConfig{Driver: OutputShelly2, Host: server.URL, Channel: 1, Password: "wrong"})
+	err = out.Set(false)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected unauthorized error, got: %v", err)
+	}
+}
+
+func TestTasmotaRelay(t *testing.T) {
+	multi := newFakeRelays()
+	multiServer := httptest.NewServer(multi.handleTasmota(false))
+	defer multiServer.Close()
+	single := newFakeRelays()
+	singleServer := httptest.NewServer(single.handleTasmota(true))
+	defer singleServer.Close()
+
+	// POWER2 key of multi relay device
+	checkRelay(t, multi, 2, newTestRelayOutput(t, &OutputConfig{Driver: OutputTasmota, Host: multiServer.URL, Channel: 2}))
+	if multi.get(1) {
+		t.Error("other channel should not be switched")
+	}
+	// POWER key of single relay device, Power (channel 0) and Power1 (channel 1)
+	checkRelay(t, single, 1, newTestRelayOutput(t, &OutputConfig{Driver: OutputTasmota, Host: singleServer.URL}))
+	checkRelay(t, single, 1, newTestRelayOutput(t, &OutputConfig{Driver: OutputTasmota, Host: singleServer.URL, Channel: 1}))
+}
+
+func TestModbusRelay(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	relays := newFakeRelays()
+	go relays.serveModbus(listener)
+
+	checkRelay(t, relays, 3, newTestRelayOutput(t, &OutputConfig{Driver: OutputModbus, Host: listener.Addr().String(), Channel: 3}))
+	if relays.get(0) {
+		t.Error("other coil should not be switched")
+	}
+}
+
+func TestRelayOutputRetry(t *testing.T) {
+	relays := newFakeRelays()
+	server := httptest.NewServer(http.HandlerFunc(relays.handleShelly2))
+	defer server.Close()
+	out := newTestRelayOutput(t, &OutputConfig{Driver: OutputShelly2, Host: server.URL})
+
+	// set fails twice, third try (second retry) with read back succeeds
+	relays.configure(2, false)
+	err := out.Set(true)
+	if err != nil {
+		t.Fatalf("expected success after retries, got: %v", err)
+	}
+	if requests := relays.configure(3, false); requests != 4 || !relays.get(0) {
+		t.Errorf("expected 2 failed and set+get requests, got %d requests, state [%v]", requests, relays.get(0))
+	}
+
+	err = out.Set(false)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("expected error after retries, got: %v", err)
+	}
+	if !relays.get(0) {
+		t.Error("relay should not be switched when all tries failed")
+	}
+}
+
+func TestRelayOutputMismatch(t *testing.T) {
+	relays := newFakeRelays()
+	server := httptest.NewServer(relays.handleTasmota(false))
+	defer server.Close()
+	out := newTestRelayOutput(t, &OutputConfig{Driver: OutputTasmota, Host: server.URL, Channel: 1})
+
+	relays.configure(0, true)
+	err := out.Set(true)
+	if err == nil || !strings.Contains(err.Error(), "relay reports [false] after setting [true]") {
+		t.Errorf("expected read back mismatch error, got: %v", err)
+	}
+	// set and read back on each try
+	if requests := relays.configure(0, false); requests != 2*(out.Retries+1) {
+		t.Errorf("expected %d requests (with retries), got %d", 2*(out.Retries+1), requests)
+	}
+
+	err = out.Set(true)
+	if err != nil {
+		t.Error(err)
+	}
+}
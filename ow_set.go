@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -16,9 +16,12 @@ type OwSet struct {
 	Path        string `json:",omitempty"`
 	SlavePrefix string `json:",omitempty"`
 	Debug       bool   `json:",omitempty"`
+	WatchConfig bool   `json:",omitempty"`
 
 	Sensors []*OwSlave `json:",omitempty"`
 
+	OwServer *OwServerBus `json:",omitempty"`
+
 	LogInflux *InfluxWriter `json:",omitempty"`
 	SendHttp  *HttpWriter   `json:",omitempty"`
 
@@ -26,12 +29,31 @@ type OwSet struct {
 	OffPeak     *OffPeak          `json:",omitempty"`
 	EnergyPanel *VictronGridMeter `json:",omitempty"`
 
+	StateFile string `json:",omitempty"`
+
 	RefreshSeconds int `json:",omitempty"`
+	ReadRetries    int `json:",omitempty"`
+	StaleSeconds   int `json:",omitempty"`
 	Updated        time.Time
 
+	bus             BusBackend
+	config          []byte
+	configPath      string
+	configModTime   time.Time // guarded by configLock
+	secrets         []string  // values read from secret files, hidden in json output
 	refreshInterval time.Duration
 	tick            *time.Ticker
+	stop, stopped   chan struct{}
 	blocker         sync.Mutex
+	// configLock is held by whole cycle, by config changes (api, reload, shutdown) and by http
+	// handlers reading or changing thermostats
+	configLock sync.Mutex
+
+	stateLock    sync.Mutex
+	savedState   []byte
+	savedStages  []byte
+	stagesSaved  time.Time
+	configStates []byte
 }
 
 func (os *OwSet) LogDebug(message string) {
@@ -54,44 +76,115 @@ func (os *OwSet) CheckIfSet() bool {
 	return true
 }
 
+func (os *OwSet) initBus() {
+	if len(os.Path) == 0 {
+		os.Path = "/sys/bus/w1/devices"
+	}
+	if os.OwServer != nil {
+		os.OwServer.init(os.SlavePrefix)
+		os.bus = os.OwServer
+		return
+	}
+
+	os.bus = &SysfsBus{Path: os.Path, SlavePrefix: os.SlavePrefix}
+}
+
 func (os *OwSet) Set(configPath ...string) error {
 
-	os.Path = "/sys/bus/w1/devices"
-	os.SlavePrefix = "28-"
+	os.initBus()
 
 	if os.RefreshSeconds == 0 {
 		os.RefreshSeconds = 15
 	}
 	os.refreshInterval, _ = time.ParseDuration(fmt.Sprintf("%ds", os.RefreshSeconds))
+	if os.StaleSeconds == 0 {
+		os.StaleSeconds = 3 * os.RefreshSeconds
+	}
 
 	if len(configPath) == 0 {
 		return nil
 	}
 
-	configFile, err := ioutil.ReadFile(configPath[0])
+	configFile, err := readConfigFile(configPath[0])
 	if err != nil {
 		return fmt.Errorf("OwSet Set: error openning config file:\n %w", err)
 	}
 
 	os.Log(fmt.Sprintf("Loading config form file: %s\n", configPath))
-	err = json.Unmarshal([]byte(configFile), os)
+	err = os.parseConfig(configFile)
 	if err != nil {
-		return fmt.Errorf("OwSet Set: error reading config(json) into OwSet:\n %w", err)
+		return fmt.Errorf("OwSet Set:\n%w", err)
+	}
+	os.configPath = configPath[0]
+
+	for _, slave := range os.Sensors {
+		if slave.Thermostat != nil {
+			err = slave.Thermostat.ReadState()
+			if err != nil {
+				return fmt.Errorf("OwSet Set | thermostat ReadState failed:\n%w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseConfig reads config (json) into OwSet, sets defaults and checks thermostats, hardware is not touched.
+// Environment variables and secret files are resolved, but kept config document is the original one.
+func (os *OwSet) parseConfig(configFile []byte) error {
+	resolved, secrets, errs := resolveConfigSecrets(configFile)
+	errs = append(errs, checkConfigDocument(resolved)...)
+
+	err := json.Unmarshal(resolved, os)
+	if err != nil {
+		if len(errs) > 0 {
+			return errs
+		}
+		return fmt.Errorf("OwSet parseConfig: error reading config(json) into OwSet:\n %w", err)
+	}
+	os.config = configFile
+	os.secrets = secrets
+
+	os.initBus()
+
+	if os.RefreshSeconds == 0 {
+		os.RefreshSeconds = 15
+	}
+	os.refreshInterval = time.Duration(os.RefreshSeconds) * time.Second
+	if os.StaleSeconds == 0 {
+		os.StaleSeconds = 3 * os.RefreshSeconds
 	}
 
 	if os.Server != nil {
 		os.Server.set = os
 	}
 
-	for _, slave := range os.Sensors {
+	for ix, slave := range os.Sensors {
 		slave.InitId()
 
+		err = slave.InitVirtual()
+		if err != nil {
+			errs.add(fmt.Sprintf("$.Sensors[%d].Virtual", ix), "%v", err)
+		}
+
+		if slave.Calibration != nil {
+			err = slave.Calibration.Init()
+			if err != nil {
+				errs.add(fmt.Sprintf("$.Sensors[%d].Calibration", ix), "%v", err)
+			}
+		}
+
 		err = slave.InitThermo()
 		if err != nil {
-			return fmt.Errorf("OwSet Set | error initializing thermostat:\n%v", err)
+			errs.add(fmt.Sprintf("$.Sensors[%d].Thermostat", ix), "%v", err)
 		}
 	}
 
+	errs = append(errs, os.Validate()...)
+	if len(errs) > 0 {
+		return errs
+	}
+
 	return nil
 }
 
@@ -109,38 +202,40 @@ func (os *OwSet) InitSlaves(settings ...string) error {
 		return fmt.Errorf("OwSet InitSlaves: set was not set properly (should ran OwSet.Set)")
 	}
 
-	devs, err := ioutil.ReadDir(os.Path)
+	devices, err := os.bus.Discover()
 	if err != nil {
-		return fmt.Errorf("OwSet InitSlaves: error reading dir (%s):\n%w", os.Path, err)
+		return fmt.Errorf("OwSet InitSlaves: bus discover failed:\n%w", err)
 	}
 
 	var zeroId, alreadyHere *OwSlave
 
-	for _, dev := range devs {
-		if strings.HasPrefix(dev.Name(), os.SlavePrefix) {
-			wslave, err := ioutil.ReadFile(filepath.Join(os.Path, dev.Name(), "w1_slave"))
-			if err == nil {
-				id, errId := strconv.ParseUint(strings.TrimPrefix(dev.Name(), os.SlavePrefix), 16, 64)
-				val, errVal := strconv.ParseUint(string(wslave[69:74]), 10, 64)
-				if string(wslave[36:39]) == "YES" && errId == nil && errVal == nil {
-
-					alreadyHere = os.GetSlaveById(id)
-					if alreadyHere != nil {
-
-						alreadyHere.SetFromInt(val)
-					} else {
-
-						zeroId = os.GetSlaveById(0)
-						if zeroId == nil {
-							zeroId = &OwSlave{Id: id}
-						}
-						zeroId.SetFromInt(val)
-						os.Sensors = append(os.Sensors, zeroId)
-					}
+	for _, dev := range devices {
+		readout, err := os.bus.Read(dev)
+		if err == nil && readout.CrcOk {
+
+			alreadyHere = os.GetSlaveById(dev.Id)
+			if alreadyHere != nil {
+
+				alreadyHere.Family = dev.Family
+				err = alreadyHere.SetFromInt(readout.Value)
+				if err != nil {
+					alreadyHere.SetFailed(SlaveImplausible, err, os.staleAfter())
+				}
+			} else {
+
+				zeroId = os.getUnassignedSlave()
+				if zeroId == nil {
+					zeroId = &OwSlave{}
 				}
+				zeroId.Id = dev.Id
+				zeroId.Family = dev.Family
+				err = zeroId.SetFromInt(readout.Value)
+				if err != nil {
+					zeroId.SetFailed(SlaveImplausible, err, os.staleAfter())
+				}
+				os.Sensors = append(os.Sensors, zeroId)
 			}
 		}
-
 	}
 	os.Updated = time.Now()
 
@@ -157,6 +252,17 @@ func (os *OwSet) GetSlaveById(id uint64) *OwSlave {
 	return nil
 }
 
+// getUnassignedSlave returns first configured sensor without Id (virtual sensors are skipped).
+func (os *OwSet) getUnassignedSlave() *OwSlave {
+	for _, slave := range os.Sensors {
+		if slave.Id == 0 && slave.Virtual == nil {
+			return slave
+		}
+	}
+
+	return nil
+}
+
 func (os *OwSet) GetSlaveByName(name string) *OwSlave {
 	for _, slave := range os.Sensors {
 		if slave.Name == name {
@@ -170,7 +276,7 @@ func (os *OwSet) GetSlaveByName(name string) *OwSlave {
 func (os *OwSet) GetSlave(ident string) *OwSlave {
 	var slave *OwSlave
 	intId, err := strconv.ParseUint(ident, 10, 64)
-	if err != nil {
+	if err == nil {
 		slave = os.GetSlaveById(intId)
 		if slave != nil {
 			return slave
@@ -192,106 +298,223 @@ func (os *OwSet) RefreshAll() error {
 	os.blocker.Lock()
 	defer os.blocker.Unlock()
 
-	for _, slave := range os.Sensors {
-
-		wslave, err := ioutil.ReadFile(filepath.Join(os.Path, fmt.Sprintf("%s%012x", os.SlavePrefix, slave.Id), "w1_slave"))
+	if converter, ok := os.bus.(BusConverter); ok {
+		err := converter.ConvertAll()
 		if err != nil {
-			return fmt.Errorf("OwSet RefreshAll: error reading file (id %x):\n%w\naborting", slave.Id, err)
-		}
-		if string(wslave[36:39]) != "YES" {
-			return fmt.Errorf("OwSet RefreshAll: (id %x) crc not YES, aborting", slave.Id)
-		}
-		wslaveRaw := string(wslave)
-		tempSlice := strings.Split(wslaveRaw, "t=")
-		if len(tempSlice) != 2 {
-			return fmt.Errorf("OwSet RefreshAll: (id %x) t= not found or found multiple times, aborting", slave.Id)
+			for _, slave := range os.Sensors {
+				if slave.Virtual == nil {
+					slave.SetFailed(SlaveError, err, os.staleAfter())
+				}
+			}
+			os.refreshVirtuals()
+			return fmt.Errorf("OwSet RefreshAll: bus conversion failed:\n%w", err)
 		}
-		if len(tempSlice[1]) == 0 {
-			return fmt.Errorf("OwSet RefreshAll: (id %x) empty value, aborting", slave.Id)
+	}
+
+	var failed []string
+	for _, slave := range os.Sensors {
+		if slave.Virtual != nil {
+			continue
 		}
-		tempStr := strings.TrimSpace(tempSlice[1])
-		val, err := strconv.ParseUint(tempStr, 10, 64)
+		err := os.refreshSlave(slave)
 		if err != nil {
-			return fmt.Errorf("OwSet RefreshAll: error parsing value (%s) (id %x):\n%w\naborting", tempStr, slave.Id, err)
+			failed = append(failed, err.Error())
 		}
-
-		slave.SetFromInt(val)
 	}
+	failed = append(failed, os.refreshVirtuals()...)
 	os.Updated = time.Now()
 
+	if len(failed) > 0 {
+		return fmt.Errorf("OwSet RefreshAll: %d of %d sensors failed:\n%s", len(failed), len(os.Sensors), strings.Join(failed, "\n"))
+	}
+
 	return nil
 }
 
+// refreshSlave reads single sensor (with ReadRetries retries), on failure last good value is kept and status is updated.
+func (os *OwSet) refreshSlave(slave *OwSlave) (err error) {
+	var readout BusReadout
+	status := SlaveOk
+
+	for attempt := 0; attempt <= os.ReadRetries; attempt++ {
+		readout, err = os.bus.Read(BusDevice{Family: slave.Family, Id: slave.Id})
+		switch {
+		case errors.Is(err, ErrBusDeviceMissing):
+			status = SlaveMissing
+		case err != nil:
+			status = SlaveError
+		case !readout.CrcOk:
+			status = SlaveCrcError
+			err = fmt.Errorf("crc not YES")
+		default:
+			err = slave.SetFromInt(readout.Value)
+			if err == nil {
+				return nil
+			}
+			status = SlaveImplausible
+		}
+	}
+
+	slave.SetFailed(status, err, os.staleAfter())
+
+	return fmt.Errorf("(id %x, %s) %s: %v", slave.Id, slave.Name, status, err)
+}
+
+func (os *OwSet) staleAfter() time.Duration {
+	return time.Duration(os.StaleSeconds) * time.Second
+}
+
 func (os *OwSet) cycling() {
-	var err error
+	defer close(os.stopped)
 	for {
 		select {
 		case <-os.tick.C:
-			err = os.RefreshAll()
-			if err != nil {
-				log.Printf("ERROR [in OwSet] during refreshing during cycling:\n%v", err)
-			} else {
-				var offPeakHeatUp, energyPanelHeatUp bool
-				if os.OffPeak != nil {
-					os.LogDebug("OffPeak enabled [OwSet], checking state")
-					offPeakHeatUp = os.OffPeak.Check()
-				}
-				if os.EnergyPanel != nil {
-					os.LogDebug("EnergyPanel enabled, ticking and checking")
-					err = os.EnergyPanel.Tick()
-					if err != nil {
-						os.Log(fmt.Sprintf("Received error from EnergyPanel.Tick(): %v", err))
-					} else {
-						os.LogDebug(os.EnergyPanel.GetDebugString())
-						energyPanelHeatUp = os.EnergyPanel.CheckAvPowerLimit()
-					}
+			os.cycle()
+		case <-os.stop:
+			return
+		}
+	}
+}
 
-				}
+// cycle runs single refresh: reads sensors, runs thermostats and sends values through writers.
+func (os *OwSet) cycle() {
+	os.configLock.Lock()
+	defer os.configLock.Unlock()
 
-				if offPeakHeatUp {
-					os.LogDebug("Received OffPeak, setting heat up mode")
-				}
-				if energyPanelHeatUp {
-					os.LogDebug("Received OK Power Limit from Energy Panel, setting heat up mode")
-				}
-				for _, slave := range os.Sensors {
-					if slave.Thermostat != nil {
-						os.LogDebug(fmt.Sprintf("Thermostat found, setting heatUpMode: %v", (energyPanelHeatUp || offPeakHeatUp)))
-						slave.Thermostat.HeatUpMode = energyPanelHeatUp || offPeakHeatUp
-					}
-				}
-				os.PrintAll()
-				os.RunThermostats()
-
-				if os.LogInflux != nil {
-					log.Print("Sendings readouts to influx")
-					err = os.LogInflux.Send(os.Sensors)
-					if err != nil {
-						log.Printf("ERROR | OwSet | sending LogInflux:\n%v", err)
-					}
-				}
-				if os.SendHttp != nil {
-					log.Print("Sending values through Http")
-					err = os.SendHttp.Send(os.Sensors)
-					if err != nil {
-						log.Printf("ERROR | OwSet | sending values by Http:\n%v", err)
-					}
-				}
+	err := os.RefreshAll()
+	if err != nil {
+		log.Printf("ERROR [in OwSet] during refreshing during cycling:\n%v", err)
+	}
+
+	var offPeakHeatUp, energyPanelHeatUp bool
+	if os.OffPeak != nil {
+		os.LogDebug("OffPeak enabled [OwSet], checking state")
+		offPeakHeatUp = os.OffPeak.Check()
+	}
+	if os.EnergyPanel != nil {
+		os.LogDebug("EnergyPanel enabled, ticking and checking")
+		err = os.EnergyPanel.Tick()
+		if err != nil {
+			os.Log(fmt.Sprintf("Received error from EnergyPanel.Tick(): %v", err))
+		} else {
+			os.LogDebug(os.EnergyPanel.GetDebugString())
+			energyPanelHeatUp = os.EnergyPanel.CheckAvPowerLimit()
+		}
+
+	}
+
+	if offPeakHeatUp {
+		os.LogDebug("Received OffPeak, setting heat up mode")
+	}
+	if energyPanelHeatUp {
+		os.LogDebug("Received OK Power Limit from Energy Panel, setting heat up mode")
+	}
+	for _, slave := range os.Sensors {
+		if slave.Thermostat != nil {
+			os.LogDebug(fmt.Sprintf("Thermostat found, setting heatUpMode: %v", (energyPanelHeatUp || offPeakHeatUp)))
+			if slave.Thermostat.Aggregate != nil {
+				slave.Thermostat.Aggregate.Update(os.GetSlaveByName)
 			}
+			slave.Thermostat.ApplySchedule()
+			slave.Thermostat.HeatUpMode = energyPanelHeatUp || offPeakHeatUp || slave.Thermostat.CheckBoost()
+		}
+	}
+	os.PrintAll()
+	os.RunThermostats()
+
+	os.SendWriters()
+
+	err = os.SaveState()
+	if err != nil {
+		log.Printf("ERROR | OwSet | saving state:\n%v", err)
+	}
+}
+
+// SendWriters sends current values through configured writers (Influx, Http).
+func (os *OwSet) SendWriters() {
+	if os.LogInflux != nil {
+		log.Print("Sendings readouts to influx")
+		err := os.LogInflux.Send(os.Sensors)
+		if err != nil {
+			log.Printf("ERROR | OwSet | sending LogInflux:\n%v", err)
+		}
+	}
+	if os.SendHttp != nil {
+		log.Print("Sending values through Http")
+		err := os.SendHttp.Send(os.Sensors)
+		if err != nil {
+			log.Printf("ERROR | OwSet | sending values by Http:\n%v", err)
 		}
 	}
 }
+
 func (os *OwSet) StartCycling() {
 	os.tick = time.NewTicker(os.refreshInterval)
+	os.stop = make(chan struct{})
+	os.stopped = make(chan struct{})
 	go os.cycling()
 }
 
+// StopCycling stops ticker and waits for running cycle to finish.
+func (os *OwSet) StopCycling() {
+	if os.tick == nil {
+		return
+	}
+
+	os.tick.Stop()
+	close(os.stop)
+	<-os.stopped
+	os.tick = nil
+}
+
+// Shutdown stops cycling and http server (waiting up to timeout), sets thermostats to their
+// shutdown state, saves runtime state and sends last values through writers.
+func (os *OwSet) Shutdown(timeout time.Duration) {
+	os.Log("Shutting down..")
+	os.StopCycling()
+
+	if os.Server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err := os.Server.Stop(ctx)
+		if err != nil {
+			log.Printf("ERROR | OwSet | stopping http server:\n%v", err)
+		}
+	}
+
+	os.configLock.Lock()
+	defer os.configLock.Unlock()
+
+	for _, slave := range os.Sensors {
+		if slave.Thermostat != nil {
+			err := slave.Thermostat.Shutdown()
+			if err != nil {
+				log.Printf("ERROR | OwSet | (%s) thermostat shutdown:\n%v", slave.Name, err)
+			}
+		}
+	}
+	closeOutputs()
+
+	err := os.saveState(true)
+	if err != nil {
+		log.Printf("ERROR | OwSet | saving state:\n%v", err)
+	}
+	os.SendWriters()
+
+	os.Log("Shutdown complete")
+}
+
 func (os *OwSet) PrintAll() {
 	freshness := time.Since(os.Updated)
 	log.Printf("Printing all sensors, last refresh %fs ago\n", freshness.Seconds())
-	fmt.Printf("id\t\tname\t\tvalue\t\tthermo?\t\tsetpoint\tstate\n")
+	fmt.Printf("id\t\tname\t\tvalue\t\tstatus\t\tage\t\tthermo?\t\tsetpoint\tstate\n")
 	for _, slave := range os.Sensors {
-		fmt.Printf("%s\t\t%x\t\t%.2f\t\t", slave.Name, slave.Id, slave.Value)
+		fmt.Printf("%s\t\t%x\t\t%.2f\t\t%s\t\t", slave.Name, slave.Id, slave.Value, slave.Status)
+		if slave.LastGood.IsZero() {
+			fmt.Printf("-\t\t")
+		} else {
+			fmt.Printf("%.0fs\t\t", slave.Age().Seconds())
+		}
 		if slave.Thermostat == nil {
 			fmt.Printf("no\t\t-\t-\n")
 		} else {
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testShutdownConfig = `{
+	"Path": "/nonexistent",
+	"Sensors": [
+		{"Name": "off", "Id": 1, "Thermostat": {"Gpio": 21, "Setpoint": 25, "ShutdownState": "off", "Output": {"Driver": "fake"}}},
+		{"Name": "on", "Id": 2, "Thermostat": {"Gpio": 22, "Setpoint": 25, "ShutdownState": "on", "Output": {"Driver": "fake"}}},
+		{"Name": "hold", "Id": 3, "Thermostat": {"Gpio": 23, "Setpoint": 25, "Output": {"Driver": "fake"}}},
+		{"Name": "inverted", "Id": 4, "Thermostat": {"Gpio": 24, "Setpoint": 25, "Invert": true, "ShutdownState": "off", "Output": {"Driver": "fake"}}},
+		{"Name": "staged", "Id": 5, "Thermostat": {"Setpoint": 25, "ShutdownState": "on", "Output": {"Driver": "fake"},
+			"Stages": [{"Gpio": 25}, {"Gpio": 26, "Offset": 2}]}}
+	]
+}`
+
+func TestShutdownStates(t *testing.T) {
+	initial := map[int]bool{21: true, 22: false, 23: true, 24: true, 25: false, 26: false}
+	expected := map[int]bool{21: false, 22: true, 23: true, 24: true, 25: true, 26: true}
+
+	fakes := map[int]Output{}
+	for gpio, state := range initial {
+		out, err := getOutput(&OutputConfig{Driver: OutputFake}, gpio)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.Set(state)
+		fakes[gpio] = out
+	}
+	// thermostats read initial output states
+	wires, _ := newTestApiSet(t, testShutdownConfig)
+	for _, slave := range wires.Sensors {
+		if slave.Thermostat != nil {
+			slave.Thermostat.lastSwitch = time.Now().Add(-time.Hour)
+			for _, stage := range slave.Thermostat.Stages {
+				stage.lastSwitch = time.Now().Add(-time.Hour)
+			}
+		}
+	}
+
+	wires.Shutdown(time.Second)
+
+	for gpio, state := range expected {
+		if actual, _ := fakes[gpio].Get(); actual != state {
+			t.Errorf("gpio %d: expected [%v] after shutdown, got [%v]", gpio, state, actual)
+		}
+	}
+}
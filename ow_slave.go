@@ -2,21 +2,109 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	SlaveOk          = "ok"
+	SlaveCrcError    = "crc-error"
+	SlaveMissing     = "missing"
+	SlaveError       = "error"
+	SlaveStale       = "stale"
+	SlaveImplausible = "implausible"
 )
 
 type OwSlave struct {
-	Name  string
-	Id    uint64
-	HexId string `json:",omitempty"`
-	Value float64
+	Name   string
+	Id     uint64
+	HexId  string `json:",omitempty"`
+	Family string `json:",omitempty"`
+	Value  float64
+	Raw    float64
 
-	Thermostat *Thermo
+	Status     string    `json:",omitempty"`
+	LastError  string    `json:",omitempty"`
+	LastGood   time.Time `json:",omitempty"`
+	StaleSince time.Time `json:",omitempty"`
+	Failures   int       `json:",omitempty"`
+
+	Thermostat  *Thermo
+	Virtual     *Virtual     `json:",omitempty"`
+	Calibration *Calibration `json:",omitempty"`
 }
 
-func (slave *OwSlave) SetFromInt(input uint64) {
-	slave.Value = float64(input) / 1000
+// SetFromInt records bus readout (in millidegrees), Raw is kept and Value is corrected and
+// checked by Calibration. Implausible readout returns error (and is not recorded).
+func (slave *OwSlave) SetFromInt(input int64) error {
+	slave.Raw = float64(input) / 1000
+	value, err := slave.Calibration.Apply(slave.Raw)
+	if err != nil {
+		return err
+	}
+	slave.setValue(value)
+
+	return nil
+}
+
+// setValue records good readout.
+func (slave *OwSlave) setValue(value float64) {
+	slave.Value = value
+	slave.Status = SlaveOk
+	slave.LastError = ""
+	slave.LastGood = time.Now()
+	slave.StaleSince = time.Time{}
+	slave.Failures = 0
+}
+
+// SetFailed records failed read, Value is kept (last good one). When last good value is older
+// than staleAfter status is changed to stale.
+func (slave *OwSlave) SetFailed(status string, err error, staleAfter time.Duration) {
+	slave.Status = status
+	if err != nil {
+		slave.LastError = err.Error()
+	}
+	slave.Failures++
+
+	if slave.Age() > staleAfter {
+		slave.Status = SlaveStale
+		if slave.StaleSince.IsZero() {
+			slave.StaleSince = time.Now()
+		}
+	}
+}
+
+// copyReadout copies last readout and status from other sensor (e.g. from previous config).
+func (slave *OwSlave) copyReadout(other *OwSlave) {
+	if len(slave.Family) == 0 {
+		slave.Family = other.Family
+	}
+	if slave.Id == 0 {
+		slave.Id = other.Id
+	}
+	slave.Value = other.Value
+	slave.Raw = other.Raw
+	slave.Status = other.Status
+	slave.LastError = other.LastError
+	slave.LastGood = other.LastGood
+	slave.StaleSince = other.StaleSince
+	slave.Failures = other.Failures
+}
+
+// Age returns time since last good readout.
+func (slave *OwSlave) Age() time.Duration {
+	if slave.LastGood.IsZero() {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Since(slave.LastGood)
+}
+
+// IsOk returns true if last read was successful.
+func (slave *OwSlave) IsOk() bool {
+	return slave.Status == SlaveOk
 }
 
 func (slave *OwSlave) InitId() bool {
@@ -25,6 +113,9 @@ func (slave *OwSlave) InitId() bool {
 		id, err := strconv.ParseUint(idSlice[len(idSlice)-1], 16, 64)
 		if err == nil {
 			slave.Id = id
+			if len(idSlice) > 1 {
+				slave.Family = strings.ToLower(idSlice[0])
+			}
 			return true
 		}
 	}
@@ -32,27 +123,115 @@ func (slave *OwSlave) InitId() bool {
 	return false
 }
 
+// InitVirtual checks virtual sensor config, virtual sensor has no bus device.
+func (slave *OwSlave) InitVirtual() error {
+	if slave.Virtual == nil {
+		return nil
+	}
+	if len(slave.Name) == 0 {
+		return fmt.Errorf("OwSlave InitVirtual: virtual sensor requires Name")
+	}
+	if slave.Id != 0 {
+		return fmt.Errorf("OwSlave InitVirtual: virtual sensor (%s) can't have Id", slave.Name)
+	}
+	if slave.Calibration != nil {
+		return fmt.Errorf("OwSlave InitVirtual: virtual sensor (%s) can't have Calibration", slave.Name)
+	}
+
+	return slave.Virtual.Init()
+}
+
+// InitThermo checks thermostat config and sets defaults, hardware is not touched (see Thermo.ReadState).
 func (slave *OwSlave) InitThermo() error {
 	if slave.Thermostat == nil {
 		return nil
 	}
 
-	if slave.Thermostat.Gpio == 0 {
+	if slave.Thermostat.Gpio == 0 && slave.Thermostat.Output.IsGpio() && len(slave.Thermostat.Stages) == 0 {
 		slave.Thermostat = nil
 		return fmt.Errorf("OwSlave InitThermo: thermostat found, but no gpio config - removing")
 	}
 
 	slave.Thermostat.Sensor = slave
+	if slave.Thermostat.Output == nil {
+		slave.Thermostat.Output = &OutputConfig{}
+	}
+	err := slave.Thermostat.Output.Init()
+	if err != nil {
+		return fmt.Errorf("OwSlave InitThermo: output config error:\n%w", err)
+	}
+	err = slave.Thermostat.initStages()
+	if err != nil {
+		return fmt.Errorf("OwSlave InitThermo: stages config error:\n%w", err)
+	}
+	if slave.Thermostat.Aggregate != nil {
+		err = slave.Thermostat.Aggregate.Init(slave.Name)
+		if err != nil {
+			return fmt.Errorf("OwSlave InitThermo: aggregate config error:\n%w", err)
+		}
+	}
+	if slave.Thermostat.Feedback != nil {
+		err = slave.Thermostat.Feedback.Init(slave.Thermostat.Output)
+		if err != nil {
+			return fmt.Errorf("OwSlave InitThermo: feedback config error:\n%w", err)
+		}
+	}
+	if slave.Thermostat.AlarmAfter == 0 {
+		slave.Thermostat.AlarmAfter = 3
+	}
+	if slave.Thermostat.ChangeoverGpio != 0 && !slave.Thermostat.Output.IsGpio() {
+		return fmt.Errorf("OwSlave InitThermo: ChangeoverGpio requires gpio output driver (not %s)", slave.Thermostat.Output.Driver)
+	}
+	if slave.Thermostat.MaxOnMinutes > 0 && slave.Thermostat.MaxOnRestMinutes == 0 {
+		slave.Thermostat.MaxOnRestMinutes = 5
+	}
+	if slave.Thermostat.MaxOnRestMinutes < 0 {
+		return fmt.Errorf("OwSlave InitThermo: MaxOnRestMinutes (%d) should not be negative", slave.Thermostat.MaxOnRestMinutes)
+	}
 	if slave.Thermostat.Hysteresis == 0 {
 		slave.Thermostat.Hysteresis = 0.5
 	}
 	if slave.Thermostat.Max == 0 {
 		slave.Thermostat.Max = 40
+		slave.Thermostat.defaultMax = true
 	}
-
-	err := slave.Thermostat.ReadState()
+	switch slave.Thermostat.Control {
+	case "":
+		slave.Thermostat.Control = ControlHysteresis
+	case ControlHysteresis:
+	case ControlPid:
+		if slave.Thermostat.Pid == nil {
+			return fmt.Errorf("OwSlave InitThermo: pid control selected, but no Pid config")
+		}
+		err := slave.Thermostat.Pid.Init()
+		if err != nil {
+			return fmt.Errorf("OwSlave InitThermo: pid config error:\n%w", err)
+		}
+	default:
+		return fmt.Errorf("OwSlave InitThermo: unknown control (%s)", slave.Thermostat.Control)
+	}
+	err = slave.Thermostat.InitMode()
+	if err != nil {
+		return fmt.Errorf("OwSlave InitThermo: mode config error:\n%w", err)
+	}
+	if slave.Thermostat.Schedule != nil {
+		err = slave.Thermostat.Schedule.Init()
+		if err != nil {
+			return fmt.Errorf("OwSlave InitThermo: schedule config error:\n%w", err)
+		}
+		slave.Thermostat.ApplySchedule()
+	}
+	switch slave.Thermostat.ShutdownState {
+	case "", "off", "on", "hold":
+	default:
+		return fmt.Errorf("OwSlave InitThermo: unknown ShutdownState (%s)", slave.Thermostat.ShutdownState)
+	}
+	if slave.Thermostat.Failsafe == nil {
+		slave.Thermostat.Failsafe = &Failsafe{}
+	}
+	err = slave.Thermostat.Failsafe.Init()
 	if err != nil {
-		return fmt.Errorf("ERROR OwSlave InitThermo ReadState failed:\n%w", err)
+		return fmt.Errorf("OwSlave InitThermo: failsafe config error:\n%w", err)
 	}
 
 	return nil
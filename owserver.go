@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	owMsgRead   int32 = 2
+	owMsgWrite  int32 = 3
+	owMsgDirAll int32 = 7
+
+	owFlagOwnet int32 = 0x00000100
+
+	owErrNoEntry int32 = -2
+
+	owReadSize = 8192
+)
+
+// owHeader is the header of both owserver request and response, for a response
+// Type field carries return value (negative on error).
+type owHeader struct {
+	Version int32
+	Payload int32
+	Type    int32
+	Flags   int32
+	Size    int32
+	Offset  int32
+}
+
+// OwServerBus reads sensors through owserver (OWFS network protocol),
+// e.g. DS9490R usb adapters or 1-wire hubs connected to other hosts.
+type OwServerBus struct {
+	Host           string
+	TimeoutSeconds int  `json:",omitempty"`
+	Uncached       bool `json:",omitempty"`
+
+	// Simultaneous triggers conversion on all sensors at once (before reading)
+	// and then reads latesttemp of each sensor.
+	Simultaneous bool `json:",omitempty"`
+	ConversionMs int  `json:",omitempty"`
+
+	families []string
+}
+
+func (ob *OwServerBus) init(slavePrefix string) {
+	if len(ob.Host) == 0 {
+		ob.Host = "localhost:4304"
+	}
+	if !strings.Contains(ob.Host, ":") {
+		ob.Host += ":4304"
+	}
+	if ob.TimeoutSeconds == 0 {
+		ob.TimeoutSeconds = 5
+	}
+	if ob.ConversionMs == 0 {
+		ob.ConversionMs = 800
+	}
+	ob.families = getBusFamilies(slavePrefix)
+}
+
+func (ob *OwServerBus) request(msgType int32, path string, data []byte, size int32) ([]byte, error) {
+	timeout := time.Duration(ob.TimeoutSeconds) * time.Second
+	conn, err := net.DialTimeout("tcp", ob.Host, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("OwServerBus request: connecting to %s failed:\n%w", ob.Host, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	payload := append([]byte(path+"\x00"), data...)
+	req := owHeader{
+		Payload: int32(len(payload)),
+		Type:    msgType,
+		Flags:   owFlagOwnet,
+		Size:    size,
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, req)
+	buf.Write(payload)
+	_, err = conn.Write(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("OwServerBus request: sending (%s) failed:\n%w", path, err)
+	}
+
+	for {
+		var resp owHeader
+		err = binary.Read(conn, binary.BigEndian, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("OwServerBus request: reading response header (%s) failed:\n%w", path, err)
+		}
+		// negative payload means keepalive ping, server is still working on request
+		if resp.Payload < 0 {
+			continue
+		}
+		if resp.Type == owErrNoEntry {
+			return nil, fmt.Errorf("OwServerBus request: %s: %w", path, ErrBusDeviceMissing)
+		}
+		if resp.Type < 0 {
+			return nil, fmt.Errorf("OwServerBus request: owserver returned error %d for %s", resp.Type, path)
+		}
+
+		body := make([]byte, resp.Payload)
+		_, err = io.ReadFull(conn, body)
+		if err != nil {
+			return nil, fmt.Errorf("OwServerBus request: reading response payload (%s) failed:\n%w", path, err)
+		}
+		if resp.Size >= 0 && int(resp.Size) < len(body) {
+			body = body[:resp.Size]
+		}
+
+		return body, nil
+	}
+}
+
+func (ob *OwServerBus) path(elem ...string) string {
+	if ob.Uncached {
+		elem = append([]string{"uncached"}, elem...)
+	}
+
+	return "/" + strings.Join(elem, "/")
+}
+
+// ReadPath returns content of owfs file (trimmed).
+func (ob *OwServerBus) ReadPath(path string) (string, error) {
+	body, err := ob.request(owMsgRead, path, nil, owReadSize)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+// WritePath writes value to owfs file.
+func (ob *OwServerBus) WritePath(path string, value string) error {
+	_, err := ob.request(owMsgWrite, path, []byte(value), int32(len(value)))
+
+	return err
+}
+
+// List returns entries of owfs directory.
+func (ob *OwServerBus) List(path string) (entries []string, err error) {
+	body, err := ob.request(owMsgDirAll, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range strings.Split(strings.TrimRight(string(body), "\x00"), ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (ob *OwServerBus) Discover() (devices []BusDevice, err error) {
+	entries, err := ob.List(ob.path())
+	if err != nil {
+		return nil, fmt.Errorf("OwServerBus Discover: listing devices failed:\n%w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry[strings.LastIndex(entry, "/")+1:]
+		family := strings.ToLower(strings.SplitN(name, ".", 2)[0])
+		if !containsString(ob.families, family) {
+			continue
+		}
+		id, err := owfsNameToId(name)
+		if err != nil {
+			continue
+		}
+		devices = append(devices, BusDevice{Family: family, Id: id})
+	}
+
+	return devices, nil
+}
+
+// ConvertAll starts temperature conversion on all sensors and waits for it to finish.
+func (ob *OwServerBus) ConvertAll() error {
+	if !ob.Simultaneous {
+		return nil
+	}
+
+	err := ob.WritePath("/simultaneous/temperature", "1")
+	if err != nil {
+		return fmt.Errorf("OwServerBus ConvertAll: triggering conversion failed:\n%w", err)
+	}
+	time.Sleep(time.Duration(ob.ConversionMs) * time.Millisecond)
+
+	return nil
+}
+
+func (ob *OwServerBus) Read(dev BusDevice) (readout BusReadout, err error) {
+	file := "temperature"
+	if ob.Simultaneous {
+		file = "latesttemp"
+	}
+
+	families := ob.families
+	if len(dev.Family) > 0 {
+		families = []string{dev.Family}
+	}
+
+	var valStr string
+	for _, family := range families {
+		valStr, err = ob.ReadPath(ob.path(owfsIdToName(family, dev.Id), file))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return readout, fmt.Errorf("OwServerBus Read: (id %x) reading %s failed:\n%w", dev.Id, file, err)
+	}
+	val, err := strconv.ParseFloat(valStr, 64)
+	if err != nil {
+		return readout, fmt.Errorf("OwServerBus Read: error parsing value (%s) (id %x):\n%w", valStr, dev.Id, err)
+	}
+
+	// owserver validates crc itself, any error is returned instead of value
+	readout.CrcOk = true
+	readout.Value = int64(math.Round(val * 1000))
+
+	return readout, nil
+}
+
+// owfsNameToId converts owfs device name (28.FF5579A21603, rom bytes in bus order)
+// to id used by kernel w1 driver (28-0316a27955ff, serial as little endian number).
+func owfsNameToId(name string) (uint64, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 || len(parts[1]) < 12 {
+		return 0, fmt.Errorf("owfsNameToId: unexpected device name (%s)", name)
+	}
+	serial, err := hex.DecodeString(parts[1][:12])
+	if err != nil {
+		return 0, fmt.Errorf("owfsNameToId: error decoding device name (%s):\n%w", name, err)
+	}
+
+	var id uint64
+	for ix := len(serial) - 1; ix >= 0; ix-- {
+		id = id<<8 | uint64(serial[ix])
+	}
+
+	return id, nil
+}
+
+func owfsIdToName(family string, id uint64) string {
+	serial := make([]byte, 6)
+	for ix := range serial {
+		serial[ix] = byte(id >> (8 * ix))
+	}
+
+	return fmt.Sprintf("%s.%X", strings.ToUpper(family), serial)
+}
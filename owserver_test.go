@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeOwServer is in-process owserver serving DIRALL, READ and WRITE from files map.
+type fakeOwServer struct {
+	listener net.Listener
+
+	lock       sync.Mutex
+	files      map[string]string
+	writes     map[string]string
+	keepalives int
+}
+
+func newFakeOwServer(t *testing.T, files map[string]string) *fakeOwServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeOwServer{listener: listener, files: files, writes: map[string]string{}}
+	go fake.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return fake
+}
+
+func (fake *fakeOwServer) serve() {
+	for {
+		conn, err := fake.listener.Accept()
+		if err != nil {
+			return
+		}
+		go fake.handle(conn)
+	}
+}
+
+func (fake *fakeOwServer) respond(conn net.Conn, ret int32, payload []byte) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, owHeader{Payload: int32(len(payload)), Type: ret, Size: int32(len(payload))})
+	buf.Write(payload)
+	conn.Write(buf.Bytes())
+}
+
+func (fake *fakeOwServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req owHeader
+	err := binary.Read(conn, binary.BigEndian, &req)
+	if err != nil {
+		return
+	}
+	payload := make([]byte, req.Payload)
+	_, err = io.ReadFull(conn, payload)
+	if err != nil {
+		return
+	}
+	path := string(payload[:bytes.IndexByte(payload, 0)])
+	data := payload[len(path)+1:]
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	for ix := 0; ix < fake.keepalives; ix++ {
+		binary.Write(conn, binary.BigEndian, owHeader{Payload: -1})
+	}
+
+	switch req.Type {
+	case owMsgDirAll:
+		var entries []string
+		prefix := strings.TrimSuffix(path, "/") + "/"
+		for file := range fake.files {
+			if !strings.HasPrefix(file, prefix) {
+				continue
+			}
+			entry := prefix + strings.SplitN(strings.TrimPrefix(file, prefix), "/", 2)[0]
+			if !containsString(entries, entry) {
+				entries = append(entries, entry)
+			}
+		}
+		fake.respond(conn, 0, []byte(strings.Join(entries, ",")))
+	case owMsgRead:
+		value, found := fake.files[path]
+		if !found {
+			fake.respond(conn, owErrNoEntry, nil)
+			return
+		}
+		fake.respond(conn, int32(len(value)), []byte(value))
+	case owMsgWrite:
+		fake.writes[path] = string(data[:req.Size])
+		fake.respond(conn, 0, nil)
+	default:
+		fake.respond(conn, -42, nil)
+	}
+}
+
+func newTestOwServerBus(fake *fakeOwServer, slavePrefix string) *OwServerBus {
+	bus := &OwServerBus{Host: fake.listener.Addr().String(), ConversionMs: 1}
+	bus.init(slavePrefix)
+
+	return bus
+}
+
+var fakeOwFiles = map[string]string{
+	"/28.FF5579A21603/temperature": "     23.125",
+	"/28.FF5579A21603/latesttemp":  "     22.5",
+	"/28.FF5579A21603/type":        "DS18B20",
+	"/10.A1E0B5010800/temperature": "    -10.5",
+	"/81.0E3C2B000000/id":          "0E3C2B000000",
+	"/settings/timeout/volatile":   "15",
+}
+
+func TestOwServerDiscover(t *testing.T) {
+	fake := newFakeOwServer(t, fakeOwFiles)
+
+	devices, err := newTestOwServerBus(fake, "").Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, dev := range devices {
+		found[dev.String()] = true
+	}
+	if len(devices) != 2 || !found["28-0316a27955ff"] || !found["10-000801b5e0a1"] {
+		t.Errorf("expected two temperature sensors, got %v", devices)
+	}
+
+	devices, err = newTestOwServerBus(fake, "10-").Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].Family != "10" {
+		t.Errorf("expected only 10 family, got %v", devices)
+	}
+}
+
+func TestOwServerRead(t *testing.T) {
+	fake := newFakeOwServer(t, fakeOwFiles)
+	bus := newTestOwServerBus(fake, "")
+
+	tests := []struct {
+		dev   BusDevice
+		value int64
+	}{
+		{BusDevice{Family: "28", Id: 0x0316a27955ff}, 23125},
+		{BusDevice{Id: 0x0316a27955ff}, 23125},
+		{BusDevice{Family: "10", Id: 0x000801b5e0a1}, -10500},
+	}
+	for _, test := range tests {
+		readout, err := bus.Read(test.dev)
+		if err != nil {
+			t.Errorf("%s: %v", test.dev, err)
+			continue
+		}
+		if readout.Value != test.value || !readout.CrcOk {
+			t.Errorf("%s: expected %d, got %+v", test.dev, test.value, readout)
+		}
+	}
+
+	_, err := bus.Read(BusDevice{Family: "28", Id: 0x1234})
+	if !errors.Is(err, ErrBusDeviceMissing) {
+		t.Errorf("expected ErrBusDeviceMissing for missing device, got %v", err)
+	}
+}
+
+func TestOwServerSimultaneous(t *testing.T) {
+	fake := newFakeOwServer(t, fakeOwFiles)
+	bus := newTestOwServerBus(fake, "")
+	bus.Simultaneous = true
+
+	err := bus.ConvertAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.lock.Lock()
+	written := fake.writes["/simultaneous/temperature"]
+	fake.lock.Unlock()
+	if written != "1" {
+		t.Errorf("conversion not triggered, written %q", written)
+	}
+
+	readout, err := bus.Read(BusDevice{Family: "28", Id: 0x0316a27955ff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readout.Value != 22500 {
+		t.Errorf("expected latesttemp 22500, got %d", readout.Value)
+	}
+}
+
+func TestOwServerKeepalive(t *testing.T) {
+	fake := newFakeOwServer(t, fakeOwFiles)
+	fake.keepalives = 3
+
+	value, err := newTestOwServerBus(fake, "").ReadPath("/28.FF5579A21603/type")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "DS18B20" {
+		t.Errorf("expected DS18B20 after keepalive pings, got %q", value)
+	}
+}
+
+func TestOwServerError(t *testing.T) {
+	fake := newFakeOwServer(t, fakeOwFiles)
+
+	_, err := newTestOwServerBus(fake, "").request(99, "/", nil, 0)
+	if err == nil || errors.Is(err, ErrBusDeviceMissing) {
+		t.Errorf("expected owserver error, got %v", err)
+	}
+}
+
+func TestOwfsNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		family string
+		id     uint64
+	}{
+		{"28.FF5579A21603", "28", 0x0316a27955ff},
+		{"10.A1E0B5010800", "10", 0x000801b5e0a1},
+		{"3B.000000000001", "3b", 0x010000000000},
+		{"42.0102030405FF", "42", 0xff0504030201},
+	}
+	for _, test := range tests {
+		id, err := owfsNameToId(test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if id != test.id {
+			t.Errorf("%s: expected id %012x, got %012x", test.name, test.id, id)
+		}
+		if name := owfsIdToName(test.family, id); name != test.name {
+			t.Errorf("%012x: expected name %s, got %s", id, test.name, name)
+		}
+	}
+
+	for _, name := range []string{"28", "28.FF55", "28.GG5579A21603"} {
+		_, err := owfsNameToId(name)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
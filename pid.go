@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ControlHysteresis = "hysteresis"
+	ControlPid        = "pid"
+)
+
+// Pid is a PID controller with output (in %) turned into slow PWM (time proportioning)
+// over CycleSeconds window.
+type Pid struct {
+	Kp, Ki, Kd     float64
+	OutMin, OutMax float64 `json:",omitempty"`
+	CycleSeconds   int     `json:",omitempty"`
+
+	P, I, D, Output float64
+
+	lastValue  float64
+	lastTime   time.Time
+	cycleStart time.Time
+}
+
+func (pid *Pid) Init() error {
+	if pid.OutMax == 0 {
+		pid.OutMax = 100
+	}
+	if pid.OutMin < 0 || pid.OutMax > 100 || pid.OutMin >= pid.OutMax {
+		return fmt.Errorf("Pid Init: wrong output clamps (OutMin: %v, OutMax: %v), should be within 0-100", pid.OutMin, pid.OutMax)
+	}
+	if pid.CycleSeconds == 0 {
+		pid.CycleSeconds = 600
+	}
+
+	return nil
+}
+
+func clamp(value, min, max float64) float64 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+
+	return value
+}
+
+// Update calculates new output from setpoint and current value.
+// Derivative is calculated on value (not error) to avoid kick on setpoint change,
+// integral term is clamped to output limits (anti-windup).
+func (pid *Pid) Update(setpoint, value float64) float64 {
+	now := time.Now()
+	err := setpoint - value
+
+	pid.P = pid.Kp * err
+	pid.D = 0
+	if !pid.lastTime.IsZero() {
+		dt := now.Sub(pid.lastTime).Seconds()
+		pid.I = clamp(pid.I+pid.Ki*err*dt, pid.OutMin, pid.OutMax)
+		if dt > 0 {
+			pid.D = -pid.Kd * (value - pid.lastValue) / dt
+		}
+	}
+	pid.lastValue = value
+	pid.lastTime = now
+
+	pid.Output = clamp(pid.P+pid.I+pid.D, pid.OutMin, pid.OutMax)
+
+	return pid.Output
+}
+
+// Reset makes next Update skip integral and derivative step, used when value was not available.
+func (pid *Pid) Reset() {
+	pid.lastTime = time.Time{}
+}
+
+// GetState returns output state for current moment: on for first Output % of every cycle.
+func (pid *Pid) GetState() bool {
+	cycle := time.Duration(pid.CycleSeconds) * time.Second
+	if pid.cycleStart.IsZero() || time.Since(pid.cycleStart) >= cycle {
+		pid.cycleStart = time.Now()
+	}
+
+	return time.Since(pid.cycleStart) < time.Duration(float64(cycle)*pid.Output/100)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestPid(t *testing.T, pid *Pid) *Pid {
+	t.Helper()
+	err := pid.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+func isClose(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+func TestPidUpdate(t *testing.T) {
+	pid := newTestPid(t, &Pid{Kp: 10, Ki: 0.1, Kd: 20})
+
+	// first update is proportional only
+	pid.Update(50, 45)
+	if pid.P != 50 || pid.I != 0 || pid.D != 0 || pid.Output != 50 {
+		t.Fatalf("unexpected first update: P %v, I %v, D %v, Output %v", pid.P, pid.I, pid.D, pid.Output)
+	}
+
+	// 10 s later value rises by 1 degree: I += 0.1*4*10, D = -20*1/10
+	pid.lastTime = time.Now().Add(-10 * time.Second)
+	pid.Update(50, 46)
+	if pid.P != 40 || !isClose(pid.I, 4) || !isClose(pid.D, -2) || !isClose(pid.Output, 42) {
+		t.Errorf("unexpected update: P %v, I %v, D %v, Output %v", pid.P, pid.I, pid.D, pid.Output)
+	}
+
+	// setpoint change doesn't kick derivative (it is calculated on value)
+	pid.lastTime = time.Now().Add(-10 * time.Second)
+	pid.Update(60, 46)
+	if pid.D != 0 {
+		t.Errorf("expected no derivative kick on setpoint change, got D %v", pid.D)
+	}
+
+	// reset skips integral and derivative step
+	i := pid.I
+	pid.Reset()
+	pid.Update(60, 40)
+	if pid.I != i || pid.D != 0 {
+		t.Errorf("expected integral kept and no derivative after reset, got I %v, D %v", pid.I, pid.D)
+	}
+}
+
+func TestPidClamp(t *testing.T) {
+	pid := newTestPid(t, &Pid{Kp: 1, Ki: 1, OutMin: 10, OutMax: 80})
+
+	// long error: integral is limited to OutMax (anti-windup), output too
+	pid.Update(100, 0)
+	pid.lastTime = time.Now().Add(-time.Hour)
+	pid.Update(100, 0)
+	if pid.I != 80 || pid.Output != 80 {
+		t.Errorf("expected integral and output clamped to 80, got I %v, Output %v", pid.I, pid.Output)
+	}
+
+	// windup would keep output high, clamped integral follows at once
+	pid.lastTime = time.Now().Add(-100 * time.Second)
+	pid.Update(0, 50)
+	if pid.I != 10 || pid.Output != 10 {
+		t.Errorf("expected integral and output clamped to OutMin 10, got I %v, Output %v", pid.I, pid.Output)
+	}
+}
+
+func TestPidGetState(t *testing.T) {
+	pid := newTestPid(t, &Pid{Kp: 1, CycleSeconds: 100})
+	pid.Output = 30
+
+	if !pid.GetState() {
+		t.Error("expected on at start of cycle")
+	}
+	pid.cycleStart = time.Now().Add(-29 * time.Second)
+	if !pid.GetState() {
+		t.Error("expected on within first 30% of cycle")
+	}
+	pid.cycleStart = time.Now().Add(-31 * time.Second)
+	if pid.GetState() {
+		t.Error("expected off after 30% of cycle")
+	}
+	// next cycle starts after CycleSeconds
+	pid.cycleStart = time.Now().Add(-101 * time.Second)
+	if !pid.GetState() || time.Since(pid.cycleStart) > time.Second {
+		t.Error("expected new cycle started (on)")
+	}
+
+	pid.Output = 0
+	pid.cycleStart = time.Time{}
+	if pid.GetState() {
+		t.Error("expected off with zero output")
+	}
+	pid.Output = 100
+	pid.cycleStart = time.Now().Add(-99 * time.Second)
+	if !pid.GetState() {
+		t.Error("expected on for whole cycle with full output")
+	}
+}
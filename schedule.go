@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const scheduleDefaultDay = "default"
+
+// Schedule is a weekly setpoint schedule. Week keys are lowercase weekday names
+// (or "default" for days not listed), blocks of a day are sorted by Start.
+type Schedule struct {
+	Profiles map[string]float64         `json:",omitempty"`
+	Week     map[string][]ScheduleBlock `json:",omitempty"`
+
+	Profile  string            `json:",omitempty"`
+	Override *ScheduleOverride `json:",omitempty"`
+}
+
+// ScheduleBlock sets setpoint from Start (HH:MM) till next block, by Profile name or Setpoint value.
+type ScheduleBlock struct {
+	Start    string
+	Profile  string  `json:",omitempty"`
+	Setpoint float64 `json:",omitempty"`
+
+	minute int
+}
+
+// ScheduleOverride is manual setpoint change, valid until next schedule block.
+type ScheduleOverride struct {
+	Setpoint float64
+	Until    time.Time
+}
+
+func (sch *Schedule) Init() error {
+	if len(sch.Week) == 0 {
+		return fmt.Errorf("Schedule Init: no days in schedule")
+	}
+
+	for day, blocks := range sch.Week {
+		if day != scheduleDefaultDay && parseWeekday(day) < 0 {
+			return fmt.Errorf("Schedule Init: unknown day (%s)", day)
+		}
+		for ix := range blocks {
+			block := &blocks[ix]
+			start, err := time.Parse("15:04", block.Start)
+			if err != nil {
+				return fmt.Errorf("Schedule Init: (%s) wrong block start (%s), expected HH:MM:\n%w", day, block.Start, err)
+			}
+			block.minute = start.Hour()*60 + start.Minute()
+			if ix > 0 && block.minute <= blocks[ix-1].minute {
+				return fmt.Errorf("Schedule Init: (%s) blocks should be sorted by start (%s after %s)", day, block.Start, blocks[ix-1].Start)
+			}
+			if len(block.Profile) > 0 {
+				if _, ok := sch.Profiles[block.Profile]; !ok {
+					return fmt.Errorf("Schedule Init: (%s %s) unknown profile (%s)", day, block.Start, block.Profile)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseWeekday(name string) time.Weekday {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.ToLower(day.String()) == name {
+			return day
+		}
+	}
+
+	return -1
+}
+
+func (sch *Schedule) getDayBlocks(day time.Weekday) []ScheduleBlock {
+	blocks, ok := sch.Week[strings.ToLower(day.String())]
+	if !ok {
+		blocks = sch.Week[scheduleDefaultDay]
+	}
+
+	return blocks
+}
+
+// getBlock returns block active at given time and start time of the next block.
+func (sch *Schedule) getBlock(when time.Time) (current *ScheduleBlock, next time.Time) {
+	midnight := time.Date(when.Year(), when.Month(), when.Day(), 0, 0, 0, 0, when.Location())
+	minute := when.Hour()*60 + when.Minute()
+
+	// looking back for current block (up to a week)
+	for back := 0; back <= 7 && current == nil; back++ {
+		blocks := sch.getDayBlocks(midnight.AddDate(0, 0, -back).Weekday())
+		for ix := len(blocks) - 1; ix >= 0; ix-- {
+			if back > 0 || blocks[ix].minute <= minute {
+				current = &blocks[ix]
+				break
+			}
+		}
+	}
+
+	// looking forward for next block start
+	for forward := 0; forward <= 7; forward++ {
+		day := midnight.AddDate(0, 0, forward)
+		for _, block := range sch.getDayBlocks(day.Weekday()) {
+			if forward > 0 || block.minute > minute {
+				return current, day.Add(time.Duration(block.minute) * time.Minute)
+			}
+		}
+	}
+
+	return current, time.Time{}
+}
+
+func (sch *Schedule) getBlockSetpoint(block *ScheduleBlock) float64 {
+	if len(block.Profile) > 0 {
+		return sch.Profiles[block.Profile]
+	}
+
+	return block.Setpoint
+}
+
+// GetSetpoint returns setpoint for given time, ok is false if there is no block in schedule.
+func (sch *Schedule) GetSetpoint(when time.Time) (setpoint float64, ok bool) {
+	if sch.Override != nil {
+		if when.Before(sch.Override.Until) {
+			return sch.Override.Setpoint, true
+		}
+		log.Printf("Schedule: override (%v) expired", sch.Override.Setpoint)
+		sch.Override = nil
+	}
+
+	block, _ := sch.getBlock(when)
+	if block == nil {
+		return 0, false
+	}
+	sch.Profile = block.Profile
+
+	return sch.getBlockSetpoint(block), true
+}
+
+// SetOverride sets manual setpoint valid until next schedule block.
+func (sch *Schedule) SetOverride(setpoint float64, when time.Time) {
+	_, next := sch.getBlock(when)
+	sch.Override = &ScheduleOverride{Setpoint: setpoint, Until: next}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestSchedule(t *testing.T) *Schedule {
+	t.Helper()
+	sch := &Schedule{
+		Profiles: map[string]float64{"comfort": 22, "night": 17},
+		Week: map[string][]ScheduleBlock{
+			"default":  {{Start: "06:00", Setpoint: 21}, {Start: "22:00", Profile: "night"}},
+			"saturday": {{Start: "08:00", Profile: "comfort"}, {Start: "23:30", Profile: "night"}},
+		},
+	}
+	err := sch.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sch
+}
+
+// 2024-01-01 is monday
+func testTime(day, hour, minute int) time.Time {
+	return time.Date(2024, 1, day, hour, minute, 0, 0, time.Local)
+}
+
+func TestScheduleGetBlock(t *testing.T) {
+	sch := newTestSchedule(t)
+	tests := []struct {
+		name     string
+		when     time.Time
+		setpoint float64
+		next     time.Time
+	}{
+		{"monday day", testTime(1, 12, 0), 21, testTime(1, 22, 0)},
+		{"block start", testTime(1, 6, 0), 21, testTime(1, 22, 0)},
+		{"monday evening", testTime(1, 23, 0), 17, testTime(2, 6, 0)},
+		{"after midnight (previous day block)", testTime(2, 2, 0), 17, testTime(2, 6, 0)},
+		{"saturday before first block (friday night)", testTime(6, 7, 0), 17, testTime(6, 8, 0)},
+		{"saturday", testTime(6, 9, 0), 22, testTime(6, 23, 30)},
+		{"saturday night to sunday (default)", testTime(6, 23, 45), 17, testTime(7, 6, 0)},
+		{"sunday after midnight (saturday night)", testTime(7, 1, 0), 17, testTime(7, 6, 0)},
+	}
+
+	for _, test := range tests {
+		block, next := sch.getBlock(test.when)
+		if block == nil {
+			t.Errorf("%s: no block found", test.name)
+			continue
+		}
+		if setpoint := sch.getBlockSetpoint(block); setpoint != test.setpoint {
+			t.Errorf("%s: expected setpoint %v, got %v", test.name, test.setpoint, setpoint)
+		}
+		if !next.Equal(test.next) {
+			t.Errorf("%s: expected next block at %v, got %v", test.name, test.next, next)
+		}
+	}
+}
+
+func TestScheduleSingleDay(t *testing.T) {
+	// only monday listed, other days look back to monday blocks
+	sch := &Schedule{Week: map[string][]ScheduleBlock{"monday": {{Start: "07:00", Setpoint: 20}}}}
+	err := sch.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setpoint, ok := sch.GetSetpoint(testTime(4, 12, 0))
+	if !ok || setpoint != 20 {
+		t.Errorf("expected monday block on thursday, got %v (ok: %v)", setpoint, ok)
+	}
+	if _, next := sch.getBlock(testTime(4, 12, 0)); !next.Equal(testTime(8, 7, 0)) {
+		t.Errorf("expected next block on next monday, got %v", next)
+	}
+}
+
+func TestScheduleOverride(t *testing.T) {
+	sch := newTestSchedule(t)
+
+	sch.SetOverride(24, testTime(1, 10, 0))
+	if sch.Override == nil || !sch.Override.Until.Equal(testTime(1, 22, 0)) {
+		t.Fatalf("expected override until next block (22:00), got %+v", sch.Override)
+	}
+	setpoint, ok := sch.GetSetpoint(testTime(1, 21, 59))
+	if !ok || setpoint != 24 {
+		t.Errorf("expected override setpoint before next block, got %v", setpoint)
+	}
+
+	// override expires at next block
+	setpoint, _ = sch.GetSetpoint(testTime(1, 22, 0))
+	if setpoint != 17 || sch.Override != nil {
+		t.Errorf("expected night block after override expired, got %v (override %+v)", setpoint, sch.Override)
+	}
+	if sch.Profile != "night" {
+		t.Errorf("expected night profile, got %q", sch.Profile)
+	}
+
+	// override set late in the evening lasts till morning block
+	sch.SetOverride(19, testTime(1, 23, 0))
+	if !sch.Override.Until.Equal(testTime(2, 6, 0)) {
+		t.Errorf("expected override until 06:00 next day, got %v", sch.Override.Until)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envPattern matches ${NAME} and ${NAME:-default}, $${ is an escaped (literal) ${.
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv replaces ${NAME} references with environment variables, unset variable without default is an error.
+func expandEnv(value string) (string, error) {
+	var missing []string
+	expanded := envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		groups := envPattern.FindStringSubmatch(match)
+		env, found := os.LookupEnv(groups[1])
+		if found {
+			return env
+		}
+		if len(groups[2]) > 0 {
+			return groups[3]
+		}
+		missing = append(missing, groups[1])
+		return ""
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	return expanded, nil
+}
+
+// readSecretFile reads secret value from file, trailing newline is removed.
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// getSecretFileField returns string field which value can be read from file given with key (e.g. TokenFile
+// or Token_file for Token), only if struct has no field named as key.
+func getSecretFileField(key string, fields map[string]reflect.StructField) (reflect.StructField, bool) {
+	lower := strings.ToLower(key)
+	if _, found := fields[lower]; found {
+		return reflect.StructField{}, false
+	}
+
+	var base string
+	switch {
+	case strings.HasSuffix(lower, "_file"):
+		base = strings.TrimSuffix(lower, "_file")
+	case strings.HasSuffix(lower, "file"):
+		base = strings.TrimSuffix(lower, "file")
+	default:
+		return reflect.StructField{}, false
+	}
+
+	field, found := fields[base]
+	if !found || field.Type.Kind() != reflect.String {
+		return reflect.StructField{}, false
+	}
+
+	return field, true
+}
+
+// credentialFields are names of config fields which values expanded from ${NAME} are added to secrets.
+var credentialFields = map[string]bool{"Token": true, "User": true, "Password": true}
+
+// resolveSecrets walks config document along OwSet structure, expands ${NAME} in string values and replaces
+// <Field>File / <Field>_file keys with content of given file (file contents and credentials expanded
+// from environment are added to secrets).
+func resolveSecrets(value interface{}, t reflect.Type, path string, secrets *[]string) (resolved interface{}, errs ConfigErrors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+		fields := getJsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		result := map[string]interface{}{}
+		for _, key := range keys {
+			elem := obj[key]
+			field, found := fields[strings.ToLower(key)]
+			if found {
+				var elemErrs ConfigErrors
+				result[key], elemErrs = resolveSecrets(elem, field.Type, path+"."+key, secrets)
+				errs = append(errs, elemErrs...)
+				// credential given with ${NAME} is a secret too
+				if expanded, ok := result[key].(string); ok && credentialFields[field.Name] && len(expanded) > 0 && expanded != elem {
+					*secrets = append(*secrets, expanded)
+				}
+				continue
+			}
+
+			field, found = getSecretFileField(key, fields)
+			if !found {
+				result[key] = elem
+				continue
+			}
+			filePath, ok := elem.(string)
+			if !ok {
+				errs.add(path+"."+key, "expected file path string, got %s", getJsonKind(elem))
+				continue
+			}
+			for other := range obj {
+				if strings.EqualFold(other, field.Name) {
+					errs.add(path+"."+key, "both %s and %s are set", key, other)
+				}
+			}
+			filePath, err := expandEnv(filePath)
+			if err != nil {
+				errs.add(path+"."+key, "%v", err)
+				continue
+			}
+			secret, err := readSecretFile(filePath)
+			if err != nil {
+				errs.add(path+"."+key, "reading secret file failed: %v", err)
+				continue
+			}
+			result[field.Name] = secret
+			if len(secret) > 0 {
+				*secrets = append(*secrets, secret)
+			}
+		}
+		return result, errs
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			return value, nil
+		}
+		result := make([]interface{}, len(list))
+		for ix, elem := range list {
+			var elemErrs ConfigErrors
+			result[ix], elemErrs = resolveSecrets(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, ix), secrets)
+			errs = append(errs, elemErrs...)
+		}
+		return result, errs
+	case reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+		result := map[string]interface{}{}
+		for key, elem := range obj {
+			var elemErrs ConfigErrors
+			result[key], elemErrs = resolveSecrets(elem, t.Elem(), path+"."+key, secrets)
+			errs = append(errs, elemErrs...)
+		}
+		return result, errs
+	case reflect.String:
+		str, ok := value.(string)
+		if !ok {
+			return value, nil
+		}
+		expanded, err := expandEnv(str)
+		if err != nil {
+			errs.add(path, "%v", err)
+			return value, errs
+		}
+		return expanded, nil
+	}
+
+	return value, nil
+}
+
+// resolveConfigSecrets returns config (json) with environment variables and secret files resolved and values
+// read from secret files, document which can't be parsed is returned unchanged (it is reported by checkConfigDocument).
+func resolveConfigSecrets(configFile []byte) (content []byte, secrets []string, errs ConfigErrors) {
+	var doc interface{}
+	err := json.Unmarshal(configFile, &doc)
+	if err != nil {
+		return configFile, nil, nil
+	}
+
+	resolved, errs := resolveSecrets(doc, reflect.TypeOf(OwSet{}), "$", &secrets)
+	content, err = json.Marshal(resolved)
+	if err != nil {
+		errs.add("$", "json marshal failed: %v", err)
+		return configFile, nil, errs
+	}
+
+	return content, secrets, errs
+}
+
+// redactSecrets replaces values read from secret files or environment (see resolveConfigSecrets) in json
+// output of OwSet (/state, print-config), json is searched for whole string values.
+func (os *OwSet) redactSecrets(js []byte) []byte {
+	for _, secret := range os.secrets {
+		quoted, err := json.Marshal(secret)
+		if err != nil {
+			continue
+		}
+		js = bytes.ReplaceAll(js, quoted, []byte(`"`+redactedValue+`"`))
+	}
+
+	return js
+}
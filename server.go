@@ -1,22 +1,49 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"math"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Server struct {
 	Port           uint
 	IntMultiFactor int
 
-	set *OwSet
+	// credentials (basic auth) required by config api, it is disabled when not set
+	User     string `json:",omitempty"`
+	Password string `json:",omitempty"`
+
+	set        *OwSet
+	httpServer *http.Server
+}
+
+// redactedValue replaces credentials and secrets in /state and print-config output.
+const redactedValue = "***"
+
+// MarshalJSON hides api credentials, they are used only by config api (which works on config document).
+func (srv *Server) MarshalJSON() ([]byte, error) {
+	type plainServer Server
+	shown := plainServer(*srv)
+	if len(shown.User) > 0 {
+		shown.User = redactedValue
+	}
+	if len(shown.Password) > 0 {
+		shown.Password = redactedValue
+	}
+
+	return json.Marshal(shown)
 }
 
 func (srv *Server) HandleSet(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
 	setS := &OwSlave{}
 	err := json.NewDecoder(r.Body).Decode(setS)
 	if err != nil {
@@ -37,7 +64,31 @@ func (srv *Server) HandleSet(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Sensor not found", 404)
 }
 
+func (srv *Server) saveState() {
+	err := srv.set.SaveState()
+	if err != nil {
+		log.Printf("ERROR | Server | saving state:\n%v", err)
+	}
+}
+
+// HandleResetState reverts runtime thermostat changes to config values and removes state file.
+func (srv *Server) HandleResetState(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := srv.set.ResetState()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (srv *Server) HandleState(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
 
 	js, err := json.Marshal(srv.set)
 
@@ -47,10 +98,14 @@ func (srv *Server) HandleState(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(js)
+	w.Write(srv.set.redactSecrets(js))
 }
 
+// HandleAllHeatUp starts (/heatup/on until cancelled, optionally /heatup/on/<minutes>) or cancels (/heatup/off)
+// boost on all thermostats.
 func (srv *Server) HandleAllHeatUp(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
 	urlSlice := strings.Split(r.URL.Path, "/")
 	if len(urlSlice) < 3 {
 		http.Error(w, "Bad request (url too short)", http.StatusBadRequest)
@@ -62,14 +117,77 @@ func (srv *Server) HandleAllHeatUp(w http.ResponseWriter, r *http.Request) {
 		heatUpMode = true
 	}
 
+	var minutes int
+	var err error
+	if heatUpMode && len(urlSlice) > 3 && len(urlSlice[3]) > 0 {
+		minutes, err = strconv.Atoi(urlSlice[3])
+		if err != nil {
+			http.Error(w, "Error during parsing minutes value", http.StatusBadRequest)
+			return
+		}
+	}
+
 	for _, slave := range srv.set.Sensors {
 		if slave.Thermostat != nil {
-			slave.Thermostat.HeatUpMode = heatUpMode
+			if heatUpMode {
+				slave.Thermostat.StartBoost(time.Duration(minutes)*time.Minute, false)
+			} else {
+				slave.Thermostat.CancelBoost()
+			}
 		}
 	}
+	srv.saveState()
+}
+
+// HandleBoost starts boost on single thermostat: /boost/<sensor>/<minutes> for given time,
+// /boost/<sensor>/target until target is reached, /boost/<sensor>/off cancels it.
+func (srv *Server) HandleBoost(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
+	urlSlice := strings.Split(r.URL.Path, "/")
+	if len(urlSlice) < 4 {
+		http.Error(w, "Bad request (url too short)", http.StatusBadRequest)
+		return
+	}
+
+	slave := srv.set.GetSlave(urlSlice[2])
+	if slave == nil {
+		http.Error(w, "Slave sensor not found", 404)
+		return
+	}
+	if slave.Thermostat == nil {
+		http.Error(w, "Selected slave sensor doesnt have thermostat", 404)
+		return
+	}
+
+	switch strings.ToLower(urlSlice[3]) {
+	case "off":
+		slave.Thermostat.CancelBoost()
+	case "target":
+		slave.Thermostat.StartBoost(0, true)
+	default:
+		minutes, err := strconv.Atoi(urlSlice[3])
+		if err != nil || minutes <= 0 {
+			http.Error(w, "Error during parsing minutes value", http.StatusBadRequest)
+			return
+		}
+		slave.Thermostat.StartBoost(time.Duration(minutes)*time.Minute, false)
+	}
+	srv.saveState()
+
+	js, err := json.Marshal(slave.Thermostat.Boost)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
 }
 
 func (srv *Server) HandleSetpointIncrease(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
 	urlSlice := strings.Split(r.URL.Path, "/")
 
 	var slaves []*OwSlave
@@ -81,11 +199,16 @@ func (srv *Server) HandleSetpointIncrease(w http.ResponseWriter, r *http.Request
 	}
 
 	for _, slave := range slaves {
-		slave.Thermostat.Setpoint = slave.Thermostat.Setpoint + 0.5
+		if slave != nil && slave.Thermostat != nil {
+			slave.Thermostat.SetSetpoint(slave.Thermostat.Setpoint + 0.5)
+		}
 	}
+	srv.saveState()
 }
 
 func (srv *Server) HandleSetpointDecrease(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
 	urlSlice := strings.Split(r.URL.Path, "/")
 
 	var slaves []*OwSlave
@@ -97,11 +220,16 @@ func (srv *Server) HandleSetpointDecrease(w http.ResponseWriter, r *http.Request
 	}
 
 	for _, slave := range slaves {
-		slave.Thermostat.Setpoint = slave.Thermostat.Setpoint - 0.5
+		if slave != nil && slave.Thermostat != nil {
+			slave.Thermostat.SetSetpoint(slave.Thermostat.Setpoint - 0.5)
+		}
 	}
+	srv.saveState()
 }
 
 func (srv *Server) HandleSetSetpoint(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
 	urlSlice := strings.Split(r.URL.Path, "/")
 	if len(urlSlice) < 4 {
 		http.Error(w, "Bad request (url too short)", http.StatusBadRequest)
@@ -110,7 +238,7 @@ func (srv *Server) HandleSetSetpoint(w http.ResponseWriter, r *http.Request) {
 
 	var slave *OwSlave
 	intId, err := strconv.ParseUint(urlSlice[2], 10, 64)
-	if err != nil {
+	if err == nil {
 		slave = srv.set.GetSlaveById(intId)
 	}
 	if slave == nil {
@@ -132,19 +260,145 @@ func (srv *Server) HandleSetSetpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slave.Thermostat.Setpoint = float64(spInt) / math.Pow10(srv.IntMultiFactor)
+	slave.Thermostat.SetSetpoint(float64(spInt) / math.Pow10(srv.IntMultiFactor))
+	srv.saveState()
+}
+
+func (srv *Server) HandleMode(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
+	urlSlice := strings.Split(r.URL.Path, "/")
+	if len(urlSlice) < 3 {
+		http.Error(w, "Bad request (url too short)", http.StatusBadRequest)
+		return
+	}
+
+	slave := srv.set.GetSlave(urlSlice[2])
+	if slave == nil {
+		http.Error(w, "Slave sensor not found", 404)
+		return
+	}
+	if slave.Thermostat == nil {
+		http.Error(w, "Selected slave sensor doesnt have thermostat", 404)
+		return
+	}
+
+	if len(urlSlice) > 3 && len(urlSlice[3]) > 0 {
+		err := slave.Thermostat.SetMode(strings.ToLower(urlSlice[3]))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		srv.saveState()
+	}
+
+	js, err := json.Marshal(map[string]string{"Mode": slave.Thermostat.Mode, "Action": slave.Thermostat.Action})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
+// HandleSchedule returns (GET), replaces (POST, PUT) or removes (DELETE) thermostat schedule,
+// DELETE /schedule/<sensor>/override cancels manual override.
+func (srv *Server) HandleSchedule(w http.ResponseWriter, r *http.Request) {
+	defer srv.set.lockConfig()()
+
+	urlSlice := strings.Split(r.URL.Path, "/")
+	if len(urlSlice) < 3 {
+		http.Error(w, "Bad request (url too short)", http.StatusBadRequest)
+		return
+	}
+
+	slave := srv.set.GetSlave(urlSlice[2])
+	if slave == nil {
+		http.Error(w, "Slave sensor not found", 404)
+		return
+	}
+	th := slave.Thermostat
+	if th == nil {
+		http.Error(w, "Selected slave sensor doesnt have thermostat", 404)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		schedule := &Schedule{}
+		err := json.NewDecoder(r.Body).Decode(schedule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = schedule.Init()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		th.Schedule = schedule
+		th.ApplySchedule()
+	case http.MethodDelete:
+		if len(urlSlice) > 3 && urlSlice[3] == "override" {
+			if th.Schedule != nil {
+				th.Schedule.Override = nil
+				th.ApplySchedule()
+			}
+		} else {
+			th.Schedule = nil
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	srv.saveState()
+
+	js, err := json.Marshal(th.Schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
 }
 
 func (srv *Server) Start() {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/set", srv.HandleSet)
+	mux.HandleFunc("/setpoint/", srv.HandleSetSetpoint)
+	mux.HandleFunc("/increase/", srv.HandleSetpointIncrease)
+	mux.HandleFunc("/decrease/", srv.HandleSetpointDecrease)
+	mux.HandleFunc("/heatup/", srv.HandleAllHeatUp)
+	mux.HandleFunc("/boost/", srv.HandleBoost)
+	mux.HandleFunc("/mode/", srv.HandleMode)
+	mux.HandleFunc("/schedule/", srv.HandleSchedule)
+	mux.HandleFunc("/state", srv.HandleState)
+	mux.HandleFunc("/state/reset", srv.HandleResetState)
+	mux.HandleFunc("/config", srv.authorize(srv.HandleConfig))
+	mux.HandleFunc("/config/sensors/", srv.authorize(srv.HandleConfigSensor))
 
-	http.HandleFunc("/set", srv.HandleSet)
-	http.HandleFunc("/setpoint/", srv.HandleSetSetpoint)
-	http.HandleFunc("/increase/", srv.HandleSetpointIncrease)
-	http.HandleFunc("/decrease/", srv.HandleSetpointDecrease)
-	http.HandleFunc("/heatup/", srv.HandleAllHeatUp)
-	http.HandleFunc("/state", srv.HandleState)
+	srv.httpServer = &http.Server{
+		Addr:    fmt.Sprintf(":%d", srv.Port),
+		Handler: mux,
+	}
 
 	go func() {
-		fmt.Println(http.ListenAndServe(fmt.Sprintf(":%d", srv.Port), nil))
+		err := srv.httpServer.ListenAndServe()
+		if err != http.ErrServerClosed {
+			fmt.Println(err)
+		}
 	}()
 }
+
+// Stop shuts down http server, waiting for active requests until ctx is done.
+func (srv *Server) Stop(ctx context.Context) error {
+	if srv.httpServer == nil {
+		return nil
+	}
+
+	return srv.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHandleStateHidesCredentials(t *testing.T) {
+	wires := &OwSet{}
+	err := wires.parseConfig([]byte(`{
+		"Server": {"Port": 8099, "User": "admin", "Password": "s3cret"},
+		"Sensors": [{"Name": "tank", "HexId": "28-0316a27955ff"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	wires.Server.HandleState(rec, httptest.NewRequest("GET", "/state", nil))
+	body := rec.Body.String()
+
+	if strings.Contains(body, "s3cret") || strings.Contains(body, "admin") {
+		t.Errorf("credentials found in /state: %s", body)
+	}
+	if !strings.Contains(body, `"Port":8099`) || !strings.Contains(body, `"tank"`) {
+		t.Errorf("expected server port and sensors in /state: %s", body)
+	}
+	if wires.Server.Password != "s3cret" {
+		t.Error("running config credentials should not be changed")
+	}
+}
+
+func TestHandleStateHidesSecretFiles(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "influx-token")
+	err := ioutil.WriteFile(tokenPath, []byte("supersecret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wires := &OwSet{}
+	err = wires.parseConfig([]byte(`{
+		"Server": {"Port": 8099},
+		"LogInflux": {"Host": "http://localhost:8086", "Measurment": "temp", "TokenFile": "` + tokenPath + `"},
+		"Sensors": [{"Name": "tank", "HexId": "28-0316a27955ff"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wires.LogInflux.Token != "supersecret" {
+		t.Fatalf("expected token read from file, got %q", wires.LogInflux.Token)
+	}
+
+	rec := httptest.NewRecorder()
+	wires.Server.HandleState(rec, httptest.NewRequest("GET", "/state", nil))
+	if body := rec.Body.String(); strings.Contains(body, "supersecret") || !strings.Contains(body, `"Token":"***"`) {
+		t.Errorf("secret from file not hidden in /state: %s", body)
+	}
+
+	// print-config output
+	js, err := json.MarshalIndent(wires, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed := string(wires.redactSecrets(js)); strings.Contains(printed, "supersecret") {
+		t.Errorf("secret from file not hidden in print-config: %s", printed)
+	}
+}
+
+func TestHandleStateHidesEnvCredentials(t *testing.T) {
+	t.Setenv("OWKIT_TEST_TOKEN", "envtoken")
+	t.Setenv("OWKIT_TEST_RELAY_PASSWORD", "relaypass")
+	t.Setenv("OWKIT_TEST_HOST", "influx.local")
+
+	wires := &OwSet{}
+	err := wires.parseConfig([]byte(`{
+		"Server": {"Port": 8099},
+		"LogInflux": {"Host": "http://${OWKIT_TEST_HOST}:8086", "Measurment": "temp", "Token": "${OWKIT_TEST_TOKEN}"},
+		"Sensors": [{"Name": "tank", "HexId": "28-0316a27955ff", "Thermostat": {"Gpio": 21, "Setpoint": 50,
+			"Output": {"Driver": "shelly", "Host": "http://relay.local", "User": "${OWKIT_TEST_RELAY_USER:-relayuser}", "Password": "${OWKIT_TEST_RELAY_PASSWORD}"}}}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wires.LogInflux.Token != "envtoken" {
+		t.Fatalf("expected token from environment, got %q", wires.LogInflux.Token)
+	}
+
+	rec := httptest.NewRecorder()
+	wires.Server.HandleState(rec, httptest.NewRequest("GET", "/state", nil))
+	body := rec.Body.String()
+	for _, secret := range []string{"envtoken", "relayuser", "relaypass"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("credential %s from environment not hidden in /state: %s", secret, body)
+		}
+	}
+	if !strings.Contains(body, "http://influx.local:8086") {
+		t.Errorf("expanded value of other field should be shown in /state: %s", body)
+	}
+}
+
+func TestHandlersWithCycle(t *testing.T) {
+	wires, _ := newTestApiSet(t, testApiConfig)
+	err := wires.LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := wires.Server
+	requests := []struct {
+		method, path string
+		handler      http.HandlerFunc
+	}{
+		{"GET", "/mode/tank/cool", srv.HandleMode},
+		{"GET", "/mode/tank/heat", srv.HandleMode},
+		{"GET", "/boost/tank/10", srv.HandleBoost},
+		{"GET", "/boost/tank/off", srv.HandleBoost},
+		{"GET", "/heatup/on", srv.HandleAllHeatUp},
+		{"GET", "/heatup/off", srv.HandleAllHeatUp},
+		{"GET", "/increase/tank", srv.HandleSetpointIncrease},
+		{"GET", "/decrease/tank", srv.HandleSetpointDecrease},
+		{"GET", "/setpoint/tank/22", srv.HandleSetSetpoint},
+		{"PUT", "/schedule/tank", srv.HandleSchedule},
+		{"DELETE", "/schedule/tank", srv.HandleSchedule},
+		{"POST", "/state/reset", srv.HandleResetState},
+		{"GET", "/state", srv.HandleState},
+		{"PATCH", "/config", srv.HandleConfig},
+	}
+	bodies := map[string]string{
+		"/schedule/tank": `{"Week": {"default": [{"Start": "06:00", "Setpoint": 21}]}}`,
+		"/config":        `{"RefreshSeconds": 20}`,
+	}
+
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for ix := 0; ix < 20; ix++ {
+			wires.cycle()
+		}
+	}()
+	for _, request := range requests {
+		wait.Add(1)
+		go func(method, path string, handler http.HandlerFunc) {
+			defer wait.Done()
+			for ix := 0; ix < 5; ix++ {
+				rec := httptest.NewRecorder()
+				handler(rec, httptest.NewRequest(method, path, strings.NewReader(bodies[path])))
+				if rec.Code >= 500 {
+					t.Errorf("%s %s: %d %s", method, path, rec.Code, rec.Body.String())
+				}
+			}
+		}(request.method, request.path, request.handler)
+	}
+	wait.Wait()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Stage is one output of staged thermostat (e.g. one of heating elements). Stage is engaged
+// when value falls Offset below setpoint (above in cool mode), with pid control number of engaged
+// stages follows pid output (each stage is equal band of 0-100%). Output defaults to thermostat Output.
+type Stage struct {
+	Gpio   int
+	Output *OutputConfig `json:",omitempty"`
+	Offset float64       `json:",omitempty"`
+
+	IsOn           bool
+	RuntimeSeconds float64
+	Starts         int
+
+	lastSwitch time.Time
+	lastUpdate time.Time
+}
+
+// StageCounters are stage runtime counters kept in StateFile.
+type StageCounters struct {
+	RuntimeSeconds float64
+	Starts         int
+}
+
+// initStages checks stages config and sets their outputs (thermostat Output by default).
+func (th *Thermo) initStages() error {
+	for ix, stage := range th.Stages {
+		if stage.Output == nil {
+			output := *th.Output
+			stage.Output = &output
+		}
+		err := stage.Output.Init()
+		if err != nil {
+			return fmt.Errorf("Thermo initStages: stage %d output config error:\n%w", ix+1, err)
+		}
+		if stage.Gpio == 0 && stage.Output.IsGpio() {
+			return fmt.Errorf("Thermo initStages: stage %d has no gpio", ix+1)
+		}
+		if stage.Offset < 0 {
+			return fmt.Errorf("Thermo initStages: stage %d Offset (%v) should not be negative", ix+1, stage.Offset)
+		}
+		if ix > 0 && stage.Offset < th.Stages[ix-1].Offset {
+			return fmt.Errorf("Thermo initStages: stage %d Offset (%v) lower than previous stage offset", ix+1, stage.Offset)
+		}
+	}
+
+	return nil
+}
+
+// getEngaged returns number of stages switched on.
+func (th *Thermo) getEngaged() (engaged int) {
+	for _, stage := range th.Stages {
+		if stage.IsOn {
+			engaged++
+		}
+	}
+
+	return
+}
+
+// getPidDemand returns number of stages required by pid output.
+func (th *Thermo) getPidDemand() int {
+	return int(math.Round(th.Pid.Output / 100 * float64(len(th.Stages))))
+}
+
+// getStageDemand returns number of stages to engage, state is thermostat state (at least one stage when on).
+func (th *Thermo) getStageDemand(state bool) (demand int) {
+	if !state {
+		return 0
+	}
+
+	switch {
+	case th.Failsafe.Active && th.Failsafe.Mode != "hold":
+		demand = len(th.Stages)
+	case th.Failsafe.Active || !th.getInput().IsOk():
+		demand = th.getEngaged()
+	case th.Control == ControlPid:
+		demand = th.getPidDemand()
+	default:
+		cooling := th.Mode == ModeCool || (th.Mode == ModeAuto && th.changeover == ActionCooling)
+		setpoint := th.GetSetpoint()
+		if th.Mode == ModeAuto && cooling {
+			setpoint = th.GetCoolSetpoint()
+		}
+		engaged := th.getEngaged()
+		value := th.getInput().Value
+		for ix, stage := range th.Stages {
+			// stages are counted by position, already engaged ones are released with hysteresis
+			held := ix < engaged
+			switch {
+			case cooling && held && value >= setpoint+stage.Offset-th.Hysteresis,
+				cooling && !held && value > setpoint+stage.Offset+th.Hysteresis,
+				!cooling && held && value <= setpoint-stage.Offset+th.Hysteresis,
+				!cooling && !held && value < setpoint-stage.Offset-th.Hysteresis:
+				demand++
+			}
+		}
+	}
+
+	if demand < 1 {
+		demand = 1
+	}
+	if demand > len(th.Stages) {
+		demand = len(th.Stages)
+	}
+
+	return
+}
+
+// updateRuntime adds time since last update to runtime of engaged stages.
+func (stage *Stage) updateRuntime() {
+	now := time.Now()
+	if stage.IsOn && !stage.lastUpdate.IsZero() {
+		stage.RuntimeSeconds += now.Sub(stage.lastUpdate).Seconds()
+	}
+	stage.lastUpdate = now
+}
+
+func (th *Thermo) updateStagesRuntime() {
+	for _, stage := range th.Stages {
+		stage.updateRuntime()
+	}
+}
+
+func (th *Thermo) setStage(ix int, state bool) error {
+	stage := th.Stages[ix]
+	log.Printf("Thermo setStage: (%s) stage %d to [%v]", th.Sensor.Name, ix+1, state)
+	out, err := getOutput(stage.Output, stage.Gpio)
+	if err != nil {
+		return fmt.Errorf("Thermo setStage: stage %d output failed:\n%w", ix+1, err)
+	}
+	err = out.Set(state != th.Invert)
+	if err != nil {
+		return fmt.Errorf("Thermo setStage: switching stage %d failed:\n%w", ix+1, err)
+	}
+
+	th.lastWrite = time.Now()
+
+	stage.updateRuntime()
+	if state && !stage.IsOn {
+		stage.Starts++
+	}
+	if state != stage.IsOn {
+		stage.lastSwitch = time.Now()
+	}
+	stage.IsOn = state
+
+	return nil
+}
+
+// isStageLocked checks stage minimum on/off time (thermostat MinOnSeconds and MinOffSeconds).
+func (th *Thermo) isStageLocked(stage *Stage) bool {
+	if stage.lastSwitch.IsZero() {
+		return false
+	}
+	if stage.IsOn {
+		return time.Since(stage.lastSwitch) < time.Duration(th.MinOnSeconds)*time.Second
+	}
+
+	return time.Since(stage.lastSwitch) < time.Duration(th.MinOffSeconds)*time.Second
+}
+
+// pickStage returns index of stage to switch to state: in list order (first engaged first, last
+// released first) or, with Rotate, the one with lowest (to engage) or highest (to release) runtime.
+// Stages locked by minimum on/off time are skipped unless force is set.
+func (th *Thermo) pickStage(state bool, force bool) int {
+	picked := -1
+	for ix, stage := range th.Stages {
+		if stage.IsOn == state || (!force && th.isStageLocked(stage)) {
+			continue
+		}
+		switch {
+		case picked < 0:
+			picked = ix
+		case th.Rotate && state && stage.RuntimeSeconds < th.Stages[picked].RuntimeSeconds:
+			picked = ix
+		case th.Rotate && !state && stage.RuntimeSeconds > th.Stages[picked].RuntimeSeconds:
+			picked = ix
+		case !th.Rotate && !state:
+			// last engaged is released first
+			picked = ix
+		}
+	}
+
+	return picked
+}
+
+// setStages engages or releases stages to match demand (all stages are released when state is off,
+// when switched on without demand all stages are engaged).
+func (th *Thermo) setStages(state bool) error {
+	target := 0
+	if state {
+		target = th.demand
+		if target <= 0 || target > len(th.Stages) {
+			target = len(th.Stages)
+		}
+	}
+	force := target == 0
+
+	for engaged := th.getEngaged(); engaged != target; {
+		next := engaged < target
+		ix := th.pickStage(next, force)
+		if ix < 0 {
+			log.Printf("Thermo setStages: (%s) changing stages (%d -> %d) locked by minimum on/off time", th.Sensor.Name, engaged, target)
+			return nil
+		}
+		err := th.setStage(ix, next)
+		if err != nil {
+			return err
+		}
+		if next {
+			engaged++
+		} else {
+			engaged--
+		}
+	}
+
+	return nil
+}
+
+// readStages reads state of stage outputs, thermostat is on if any stage is engaged.
+func (th *Thermo) readStages() error {
+	th.IsOn = false
+	for ix, stage := range th.Stages {
+		out, err := getOutput(stage.Output, stage.Gpio)
+		if err != nil {
+			return fmt.Errorf("Thermo readStages: stage %d output failed:\n%w", ix+1, err)
+		}
+		state, err := out.Get()
+		if err != nil {
+			return fmt.Errorf("Thermo readStages: reading stage %d failed:\n%w", ix+1, err)
+		}
+		stage.IsOn = state != th.Invert
+		stage.lastSwitch = time.Now()
+		stage.lastUpdate = stage.lastSwitch
+		th.IsOn = th.IsOn || stage.IsOn
+	}
+
+	return nil
+}
+
+// getStageCounters returns runtime counters of stages.
+func (th *Thermo) getStageCounters() (counters []StageCounters) {
+	for _, stage := range th.Stages {
+		counters = append(counters, StageCounters{RuntimeSeconds: stage.RuntimeSeconds, Starts: stage.Starts})
+	}
+
+	return
+}
+
+// setStageCounters sets runtime counters of stages, if number of stages matches.
+func (th *Thermo) setStageCounters(counters []StageCounters) {
+	if len(counters) != len(th.Stages) {
+		return
+	}
+	for ix, stage := range th.Stages {
+		stage.RuntimeSeconds = counters[ix].RuntimeSeconds
+		stage.Starts = counters[ix].Starts
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFileVersion = 1
+
+// stagesSaveInterval limits state file writes caused only by stage runtime counters.
+const stagesSaveInterval = 15 * time.Minute
+
+// StateFile keeps runtime thermostat changes (made through api) and stage runtime counters between restarts.
+type StateFile struct {
+	Version     int
+	Saved       time.Time
+	Thermostats map[string]*ThermoState
+	Stages      map[string][]StageCounters `json:",omitempty"`
+}
+
+// ThermoState is runtime part of Thermo stored in StateFile.
+type ThermoState struct {
+	Setpoint     float64
+	Mode         string    `json:",omitempty"`
+	CoolSetpoint float64   `json:",omitempty"`
+	Schedule     *Schedule `json:",omitempty"`
+	Boost        *Boost    `json:",omitempty"`
+}
+
+func getThermoState(th *Thermo) *ThermoState {
+	return &ThermoState{
+		Setpoint:     th.Setpoint,
+		Mode:         th.Mode,
+		CoolSetpoint: th.CoolSetpoint,
+		Schedule:     th.Schedule,
+		Boost:        th.Boost.getState(),
+	}
+}
+
+func (ts *ThermoState) apply(th *Thermo) error {
+	if ts.Schedule != nil {
+		err := ts.Schedule.Init()
+		if err != nil {
+			return fmt.Errorf("ThermoState apply: schedule error:\n%w", err)
+		}
+	}
+
+	previous := getThermoState(th)
+	previousBoost := th.Boost
+	th.Setpoint = ts.Setpoint
+	th.CoolSetpoint = ts.CoolSetpoint
+	th.Schedule = ts.Schedule
+	th.Boost = ts.Boost
+	th.Boost.updateRemaining()
+	err := th.SetMode(ts.Mode)
+	if err != nil {
+		th.Setpoint = previous.Setpoint
+		th.CoolSetpoint = previous.CoolSetpoint
+		th.Schedule = previous.Schedule
+		th.Boost = previousBoost
+		return fmt.Errorf("ThermoState apply: mode error:\n%w", err)
+	}
+	th.ApplySchedule()
+
+	return nil
+}
+
+func getSlaveKey(slave *OwSlave) string {
+	if len(slave.Name) > 0 {
+		return slave.Name
+	}
+
+	return fmt.Sprintf("%012x", slave.Id)
+}
+
+func (os *OwSet) getStageCounters() map[string][]StageCounters {
+	counters := map[string][]StageCounters{}
+	for _, slave := range os.Sensors {
+		if slave.Thermostat != nil && len(slave.Thermostat.Stages) > 0 {
+			counters[getSlaveKey(slave)] = slave.Thermostat.getStageCounters()
+		}
+	}
+
+	return counters
+}
+
+func (os *OwSet) getThermoStates() map[string]*ThermoState {
+	states := map[string]*ThermoState{}
+	for _, slave := range os.Sensors {
+		if slave.Thermostat != nil {
+			states[getSlaveKey(slave)] = getThermoState(slave.Thermostat)
+		}
+	}
+
+	return states
+}
+
+// LoadState applies runtime changes saved in StateFile (if configured) over config values.
+func (os *OwSet) LoadState() error {
+	os.stateLock.Lock()
+	defer os.stateLock.Unlock()
+
+	// copy of config values, used by ResetState
+	os.configStates, _ = json.Marshal(os.getThermoStates())
+
+	if len(os.StateFile) == 0 {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(os.StateFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("OwSet LoadState: error reading state file:\n%w", err)
+	}
+
+	state := StateFile{}
+	err = json.Unmarshal(content, &state)
+	if err != nil {
+		return fmt.Errorf("OwSet LoadState: error parsing state file (%s):\n%w", os.StateFile, err)
+	}
+	if state.Version != stateFileVersion {
+		return fmt.Errorf("OwSet LoadState: unsupported state file version (%d), expected %d", state.Version, stateFileVersion)
+	}
+
+	for _, slave := range os.Sensors {
+		if counters, found := state.Stages[getSlaveKey(slave)]; found && slave.Thermostat != nil {
+			slave.Thermostat.setStageCounters(counters)
+		}
+		thState, found := state.Thermostats[getSlaveKey(slave)]
+		if !found || slave.Thermostat == nil {
+			continue
+		}
+		err = thState.apply(slave.Thermostat)
+		if err != nil {
+			os.Log(fmt.Sprintf("OwSet LoadState: (%s) skipping saved state:\n%v", slave.Name, err))
+		}
+	}
+	os.Log(fmt.Sprintf("Runtime state loaded from %s (saved %v)", os.StateFile, state.Saved))
+
+	os.savedState, _ = json.Marshal(state.Thermostats)
+	os.savedStages, _ = json.Marshal(state.Stages)
+	os.stagesSaved = time.Now()
+
+	return nil
+}
+
+// SaveState writes current runtime state to StateFile (if configured and changed since last save),
+// changes of stage runtime counters only are written not more often than every stagesSaveInterval.
+func (os *OwSet) SaveState() error {
+	return os.saveState(false)
+}
+
+// saveState writes state file, with force changed stage counters are written immediately.
+func (os *OwSet) saveState(force bool) error {
+	if len(os.StateFile) == 0 {
+		return nil
+	}
+
+	os.stateLock.Lock()
+	defer os.stateLock.Unlock()
+
+	state := StateFile{
+		Version:     stateFileVersion,
+		Saved:       time.Now(),
+		Thermostats: os.getThermoStates(),
+		Stages:      os.getStageCounters(),
+	}
+	current, err := json.Marshal(state.Thermostats)
+	if err != nil {
+		return fmt.Errorf("OwSet SaveState: json marshal failed:\n%w", err)
+	}
+	stages, err := json.Marshal(state.Stages)
+	if err != nil {
+		return fmt.Errorf("OwSet SaveState: json marshal failed:\n%w", err)
+	}
+	stagesChanged := !bytes.Equal(stages, os.savedStages) && (force || time.Since(os.stagesSaved) >= stagesSaveInterval)
+	if bytes.Equal(current, os.savedState) && !stagesChanged {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return fmt.Errorf("OwSet SaveState: json marshal failed:\n%w", err)
+	}
+	err = writeFileAtomic(os.StateFile, content)
+	if err != nil {
+		return fmt.Errorf("OwSet SaveState: writing state file failed:\n%w", err)
+	}
+	os.savedState = current
+	os.savedStages = stages
+	os.stagesSaved = time.Now()
+
+	return nil
+}
+
+// ResetState removes state file and reverts thermostats to values from config.
+func (os *OwSet) ResetState() error {
+	os.stateLock.Lock()
+	defer os.stateLock.Unlock()
+
+	configStates := map[string]*ThermoState{}
+	err := json.Unmarshal(os.configStates, &configStates)
+	if err != nil {
+		return fmt.Errorf("OwSet ResetState: reading config values failed:\n%w", err)
+	}
+
+	for _, slave := range os.Sensors {
+		configState, found := configStates[getSlaveKey(slave)]
+		if !found || slave.Thermostat == nil {
+			continue
+		}
+		err := configState.apply(slave.Thermostat)
+		if err != nil {
+			return fmt.Errorf("OwSet ResetState: (%s) reverting to config failed:\n%w", slave.Name, err)
+		}
+	}
+	os.savedState = nil
+
+	if len(os.StateFile) == 0 {
+		return nil
+	}
+	err = removeIfExists(os.StateFile)
+	if err != nil {
+		return fmt.Errorf("OwSet ResetState: removing state file failed:\n%w", err)
+	}
+
+	return nil
+}
+
+func removeIfExists(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// writeFileAtomic writes file content to temporary file in the same directory and renames it.
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateFileBoost(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	config := strings.Replace(testApiConfig, `"Path": "/nonexistent",`, `"Path": "/nonexistent", "StateFile": "`+statePath+`",`, 1)
+	wires, configPath := newTestApiSet(t, config)
+	err := wires.LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	th := wires.GetSlaveByName("tank").Thermostat
+	th.StartBoost(30*time.Minute, false)
+	until := th.Boost.Until
+	err = wires.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "RemainingSeconds") {
+		t.Errorf("RemainingSeconds should not be saved: %s", saved)
+	}
+
+	// remaining time changes every cycle, state file is not rewritten
+	time.Sleep(10 * time.Millisecond)
+	th.CheckBoost()
+	err = wires.SaveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := ioutil.ReadFile(statePath); string(current) != string(saved) {
+		t.Error("state file rewritten while only remaining boost time changed")
+	}
+
+	// loaded boost keeps absolute end time, remaining time is calculated
+	loaded := &OwSet{}
+	err = loaded.Set(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loaded.LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	boost := loaded.GetSlaveByName("tank").Thermostat.Boost
+	if boost == nil || !boost.Until.Equal(until) {
+		t.Fatalf("expected boost until %v, got %+v", until, boost)
+	}
+	if boost.RemainingSeconds < 29*60 || boost.RemainingSeconds > 30*60 {
+		t.Errorf("expected about 30 minutes remaining, got %v s", boost.RemainingSeconds)
+	}
+}
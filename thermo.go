@@ -3,41 +3,194 @@ package main
 import (
 	"fmt"
 	"log"
-	"github.com/stianeikeland/go-rpio"
+	"math"
+	"time"
 )
 
 type Thermo struct {
-	Gpio		int
-	Invert		bool
+	Gpio   int
+	Invert bool
+	Output *OutputConfig `json:",omitempty"`
 
-	IsOn 			bool
-	HeatUpMode		bool
+	IsOn       bool
+	HeatUpMode bool
 
-	Setpoint, Hysteresis, Min, Max, HeatUp 			float64
-	
-	Sensor			*OwSlave		`json:"-"`
+	Setpoint, Hysteresis, Min, Max, HeatUp float64
+
+	Mode           string  `json:",omitempty"`
+	Action         string  `json:",omitempty"`
+	CoolSetpoint   float64 `json:",omitempty"`
+	Deadband       float64 `json:",omitempty"`
+	ChangeoverGpio int     `json:",omitempty"`
+
+	Control string `json:",omitempty"`
+	Pid     *Pid   `json:",omitempty"`
+
+	Failsafe *Failsafe `json:",omitempty"`
+	Schedule *Schedule `json:",omitempty"`
+	Boost    *Boost    `json:",omitempty"`
+
+	MinOnSeconds     int     `json:",omitempty"`
+	MinOffSeconds    int     `json:",omitempty"`
+	MaxCyclesPerHour int     `json:",omitempty"`
+	MaxOnMinutes     int     `json:",omitempty"`
+	MaxOnRestMinutes int     `json:",omitempty"`
+	LockoutSeconds   float64 `json:",omitempty"`
+
+	ShutdownState string `json:",omitempty"`
+
+	Aggregate *Aggregate `json:",omitempty"`
+
+	Stages []*Stage `json:",omitempty"`
+	Rotate bool     `json:",omitempty"`
+
+	Feedback   *Feedback `json:",omitempty"`
+	AlarmAfter int       `json:",omitempty"`
+	Mismatches int       `json:",omitempty"`
+	Alarm      bool      `json:",omitempty"`
+
+	lastSwitch time.Time
+	lastWrite  time.Time
+	restUntil  time.Time
+	demand     int
+	switchesOn []time.Time
+	changeover string
+	defaultMax bool
+
+	Sensor *OwSlave `json:"-"`
 }
 
 func (th *Thermo) Run() (err error) {
+	th.updateStagesRuntime()
+	err = th.checkOutput()
+	if err != nil {
+		log.Printf("Thermo Run: (%s) output check failed:\n%v", th.Sensor.Name, err)
+	}
+	state := th.IsOn
+	input := th.getInput()
 
-	if th.IsOn {
-		if th.Sensor.Value > (th.GetSetpoint() + th.Hysteresis) {
-			err = th.Set(false)
+	if th.Failsafe.Check(input) {
+		state = th.Failsafe.GetState(th.IsOn)
+	} else if !input.IsOk() {
+		log.Printf("Thermo Run: sensor %v status is %s, holding state", th.Sensor.Name, input.Status)
+	} else if th.Control == ControlPid {
+		if th.Mode == ModeCool {
+			// reversed direction: the higher value the more output
+			th.Pid.Update(-th.GetSetpoint(), -input.Value)
+		} else {
+			th.Pid.Update(th.GetSetpoint(), input.Value)
+		}
+		if len(th.Stages) > 0 {
+			state = th.getPidDemand() > 0
+		} else {
+			state = th.Pid.GetState()
 		}
 	} else {
-		if th.Sensor.Value < (th.GetSetpoint() - th.Hysteresis) {
-			err = th.Set(true)
+		state, err = th.runHysteresis()
+		if err != nil {
+			return
 		}
 	}
+
+	if th.Control == ControlPid && !input.IsOk() {
+		th.Pid.Reset()
+	}
+
+	maxOnCutoff := false
+	if state && th.IsOn && th.MaxOnMinutes > 0 && time.Since(th.lastSwitch) > time.Duration(th.MaxOnMinutes)*time.Minute {
+		log.Printf("Thermo Run: (%s) on for longer than %d minutes, forcing off", th.Sensor.Name, th.MaxOnMinutes)
+		state = false
+		maxOnCutoff = true
+	}
+	if len(th.Stages) > 0 {
+		th.demand = th.getStageDemand(state)
+	}
+
+	err = th.switchTo(state)
+	if maxOnCutoff && !th.IsOn {
+		th.restUntil = time.Now().Add(time.Duration(th.MaxOnRestMinutes) * time.Minute)
+		th.LockoutSeconds = th.GetLockout(true).Seconds()
+		log.Printf("Thermo Run: (%s) resting for %d minutes after max on time", th.Sensor.Name, th.MaxOnRestMinutes)
+	}
+	th.updateAction()
+
 	return
 }
 
-func (th *Thermo) GetSetpoint() (setpoint float64) {
+// switchTo sets output state unless it is locked by minimum on/off times or cycles per hour limit.
+func (th *Thermo) switchTo(state bool) error {
+	lockout := th.GetLockout(state)
+	th.LockoutSeconds = lockout.Seconds()
+
+	if state == th.IsOn {
+		if state && len(th.Stages) > 0 {
+			return th.setStages(true)
+		}
+		return nil
+	}
+	if lockout > 0 {
+		log.Printf("Thermo switchTo: (%s) switching to [%v] locked for %s", th.Sensor.Name, state, lockout.Round(time.Second))
+		return nil
+	}
+
+	return th.Set(state)
+}
+
+// GetLockout returns time remaining until output can be switched to requested state.
+func (th *Thermo) GetLockout(state bool) (lockout time.Duration) {
+	if state == th.IsOn || th.lastSwitch.IsZero() {
+		return 0
+	}
+
+	if th.IsOn {
+		lockout = time.Duration(th.MinOnSeconds)*time.Second - time.Since(th.lastSwitch)
+	} else {
+		lockout = time.Duration(th.MinOffSeconds)*time.Second - time.Since(th.lastSwitch)
+
+		th.cleanSwitches()
+		if th.MaxCyclesPerHour > 0 && len(th.switchesOn) >= th.MaxCyclesPerHour {
+			cyclesLockout := time.Hour - time.Since(th.switchesOn[0])
+			if cyclesLockout > lockout {
+				lockout = cyclesLockout
+			}
+		}
+		// rest after max on time cutoff
+		if restLockout := time.Until(th.restUntil); restLockout > lockout {
+			lockout = restLockout
+		}
+	}
+
+	if lockout < 0 {
+		return 0
+	}
+
+	return
+}
+
+// cleanSwitches removes switching on times older than one hour.
+func (th *Thermo) cleanSwitches() {
+	for len(th.switchesOn) > 0 && time.Since(th.switchesOn[0]) > time.Hour {
+		th.switchesOn = th.switchesOn[1:]
+	}
+}
+
+// GetSetpoint returns effective setpoint (heating setpoint in auto mode), in heat up mode
+// it is raised by HeatUp up to Max (default 40, never below Setpoint) or lowered in cool mode (down to Min, if set).
+func (th *Thermo) GetSetpoint() float64 {
+	return th.getSetpoint(th.HeatUpMode)
+}
+
+func (th *Thermo) getSetpoint(heatUp bool) (setpoint float64) {
 	setpoint = th.Setpoint
 
-	if th.HeatUpMode {
-		if th.HeatUp + th.Setpoint > th.Max {
-			setpoint = th.Max
+	if heatUp {
+		if th.Mode == ModeCool {
+			setpoint -= th.HeatUp
+			if th.Min != 0 && setpoint < th.Min {
+				setpoint = th.Min
+			}
+		} else if th.HeatUp+th.Setpoint > th.Max {
+			setpoint = math.Max(th.Max, th.Setpoint)
 		} else {
 			setpoint += th.HeatUp
 		}
@@ -45,53 +198,135 @@ func (th *Thermo) GetSetpoint() (setpoint float64) {
 	return
 }
 
+// ApplySchedule sets Setpoint from schedule (or its override), if thermostat has one.
+func (th *Thermo) ApplySchedule() {
+	if th.Schedule == nil {
+		return
+	}
+
+	setpoint, ok := th.Schedule.GetSetpoint(time.Now())
+	if ok {
+		th.Setpoint = setpoint
+	}
+}
+
+// SetSetpoint changes setpoint manually, with schedule it is an override till next schedule block.
+func (th *Thermo) SetSetpoint(setpoint float64) {
+	th.Setpoint = setpoint
+	if th.Schedule != nil {
+		th.Schedule.SetOverride(setpoint, time.Now())
+	}
+}
+
+// ReadState reads output state (without switching it).
 func (th *Thermo) ReadState() error {
-	log.Print("Thermo Set: checking gpio state")
-	err := rpio.Open()
+	log.Print("Thermo ReadState: checking output state")
+	if len(th.Stages) > 0 {
+		th.lastSwitch = time.Now()
+		return th.readStages()
+	}
+	out, err := getOutput(th.Output, th.Gpio)
 	if err != nil {
-		return fmt.Errorf("Thermo Set: opening rpio failed:\n%w", err)
+		return fmt.Errorf("Thermo ReadState: output failed:\n%w", err)
 	}
 
-	defer rpio.Close()
-
-	pin := rpio.Pin(th.Gpio)
-	pin.Output()
-	
-	state := true
-	if pin.Read() == rpio.High {
-		state = false	
+	state, err := out.Get()
+	if err != nil {
+		return fmt.Errorf("Thermo ReadState: reading output failed:\n%w", err)
 	}
-	if th.Invert {
-		th.IsOn = !state
+	th.IsOn = state != th.Invert
+	th.lastSwitch = time.Now()
+
+	return nil
+}
+
+func (th *Thermo) Set(state bool) error {
+	log.Printf("Thermo Set: received [%v] request, running.", state)
+	if len(th.Stages) > 0 {
+		err := th.setStages(state)
+		if err != nil {
+			return fmt.Errorf("Thermo Set: switching stages failed:\n%w", err)
+		}
 	} else {
-		th.IsOn = state
+		out, err := getOutput(th.Output, th.Gpio)
+		if err != nil {
+			return fmt.Errorf("Thermo Set: output failed:\n%w", err)
+		}
+
+		err = out.Set(state != th.Invert)
+		if err != nil {
+			return fmt.Errorf("Thermo Set: switching output failed:\n%w", err)
+		}
 	}
+	th.lastWrite = time.Now()
+
+	if state != th.IsOn {
+		th.lastSwitch = time.Now()
+		if state {
+			th.switchesOn = append(th.switchesOn, th.lastSwitch)
+		}
+	}
+	th.IsOn = state
 
 	return nil
 }
 
-func (th *Thermo) Set(state bool) (error) {
-	log.Print("Thermo Set: received [%v] request, running.", state)
-	err := rpio.Open()
-	if err != nil {
-		return fmt.Errorf("Thermo Set: opening rpio failed:\n%w", err)
+// Shutdown sets output to ShutdownState (off, on or hold - default).
+func (th *Thermo) Shutdown() error {
+	th.updateStagesRuntime()
+	switch th.ShutdownState {
+	case "off":
+		return th.Set(false)
+	case "on":
+		th.demand = len(th.Stages)
+		return th.Set(true)
+	default:
+		return nil
 	}
+}
 
-	defer rpio.Close()
-
-	pin := rpio.Pin(th.Gpio)
-	pin.Output()
+// getOutputKeys returns keys of thermostat output or stage outputs (see OutputConfig getKey).
+func (th *Thermo) getOutputKeys() (keys []string) {
+	if len(th.Stages) == 0 {
+		return []string{th.Output.getKey(th.Gpio)}
+	}
+	for _, stage := range th.Stages {
+		keys = append(keys, stage.Output.getKey(stage.Gpio))
+	}
 
-	th.IsOn = state
+	return keys
+}
 
-	if th.Invert {
-		state = !state
+// Release sets outputs no longer controlled by thermostat (removed from config or moved to other
+// output), skipping the ones still in use, to ShutdownState. Hold is treated as off, as nothing
+// would switch released output off again.
+func (th *Thermo) Release(inUse map[string]bool) error {
+	state := th.ShutdownState == "on"
+	set := func(oc *OutputConfig, gpio int) error {
+		key := oc.getKey(gpio)
+		if inUse[key] {
+			return nil
+		}
+		log.Printf("Thermo Release: (%s) output %s to [%v]", th.Sensor.Name, key, state)
+		out, err := getOutput(oc, gpio)
+		if err != nil {
+			return fmt.Errorf("Thermo Release: output %s failed:\n%w", key, err)
+		}
+		err = out.Set(state != th.Invert)
+		if err != nil {
+			return fmt.Errorf("Thermo Release: switching output %s failed:\n%w", key, err)
+		}
+		return nil
 	}
 
-	if state {
-		pin.Low()
-	} else {
-		pin.High()
+	if len(th.Stages) == 0 {
+		return set(th.Output, th.Gpio)
+	}
+	for _, stage := range th.Stages {
+		err := set(stage.Output, stage.Gpio)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -127,7 +362,6 @@ func (th *Thermo) CheckIfHeatUp() uint {
 // 	StartMinute, StopMinute		int
 // }
 
-
 // func (op *OffPeak) CheckIfInside(when time.Time) (result bool) {
 // 	// check if Weekday matters at all
 // 	if op.StartWeekday == op.StopWeekday {
@@ -138,7 +372,7 @@ func (th *Thermo) CheckIfHeatUp() uint {
 // 	}
 // 	tStart := time.Date(when.Year(), when.Month(), dayStart, op.StartHour, op.StartMinute, 0, 0, when.Location())
 // 	tStop := time.Date(when.Year(), when.Month(), dayStop, op.StopHour, op.StartMinute, 0, 0, when.Location())
-	
+
 // 	// check if stop is after start
 // 	if tStop.After(tStart) {
 // 		if when.After(tStart) && when.Before(tStop) {
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestThermo returns initialized thermostat with fake output and sensor reading value.
+func newTestThermo(t *testing.T, gpio int, value float64, thermo *Thermo) *Thermo {
+	t.Helper()
+	thermo.Gpio = gpio
+	thermo.Output = &OutputConfig{Driver: OutputFake}
+	slave := &OwSlave{Name: "test", Thermostat: thermo}
+	slave.setValue(value)
+
+	err := slave.InitThermo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = thermo.ReadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeOutputs)
+
+	return thermo
+}
+
+func TestThermoMaxOnRest(t *testing.T) {
+	th := newTestThermo(t, 40, 20, &Thermo{Setpoint: 25, MaxOnMinutes: 30, MaxOnRestMinutes: 10})
+
+	err := th.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !th.IsOn {
+		t.Fatal("thermostat should be on below setpoint")
+	}
+
+	th.lastSwitch = time.Now().Add(-31 * time.Minute)
+	th.Run()
+	if th.IsOn {
+		t.Fatal("thermostat should be switched off after MaxOnMinutes")
+	}
+	if th.LockoutSeconds < 9*60 {
+		t.Errorf("expected rest lockout of about 10 minutes, got %v s", th.LockoutSeconds)
+	}
+
+	// still below setpoint, rest has to be kept
+	th.Run()
+	if th.IsOn {
+		t.Error("thermostat switched on again during rest")
+	}
+
+	th.restUntil = time.Now().Add(-time.Second)
+	th.Run()
+	if !th.IsOn {
+		t.Error("thermostat should be on after rest")
+	}
+}
+
+func TestThermoMaxOnRestDefault(t *testing.T) {
+	th := newTestThermo(t, 41, 20, &Thermo{Setpoint: 25, MaxOnMinutes: 30, MinOffSeconds: 20 * 60})
+	if th.MaxOnRestMinutes != 5 {
+		t.Errorf("expected default MaxOnRestMinutes 5, got %d", th.MaxOnRestMinutes)
+	}
+
+	// output was read on start, MinOffSeconds counts from it
+	th.lastSwitch = time.Now().Add(-time.Hour)
+	th.Run()
+	if !th.IsOn {
+		t.Fatal("thermostat should be on below setpoint")
+	}
+	th.lastSwitch = time.Now().Add(-31 * time.Minute)
+	th.Run()
+	if th.IsOn {
+		t.Fatal("thermostat should be switched off after MaxOnMinutes")
+	}
+	// rest is at least MinOffSeconds
+	if th.LockoutSeconds < 19*60 {
+		t.Errorf("expected lockout of MinOffSeconds, got %v s", th.LockoutSeconds)
+	}
+}
+
+func TestThermoFailsafe(t *testing.T) {
+	tests := []struct {
+		mode     string
+		isOn     bool
+		expected bool
+	}{
+		{FailsafeOff, true, false},
+		{FailsafeOn, false, true},
+		{FailsafeHold, true, true},
+		{FailsafeHold, false, false},
+	}
+
+	for ix, test := range tests {
+		th := newTestThermo(t, 42+ix, 20, &Thermo{Setpoint: 25, Failsafe: &Failsafe{Mode: test.mode, AfterFailures: 2}})
+		th.lastSwitch = time.Now().Add(-time.Hour)
+		err := th.Set(test.isOn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sensor := th.Sensor
+
+		// one failure is below threshold, thermostat holds its state
+		sensor.SetFailed(SlaveError, nil, time.Hour)
+		th.Run()
+		if th.Failsafe.Active || th.IsOn != test.isOn {
+			t.Errorf("%s: failsafe active after one failure or state changed (on: %v)", test.mode, th.IsOn)
+		}
+
+		sensor.SetFailed(SlaveError, nil, time.Hour)
+		th.Run()
+		if !th.Failsafe.Active {
+			t.Fatalf("%s: failsafe not active after AfterFailures", test.mode)
+		}
+		if th.IsOn != test.expected {
+			t.Errorf("%s: expected output [%v] in failsafe, got [%v]", test.mode, test.expected, th.IsOn)
+		}
+
+		// recovered sensor above setpoint, thermostat is back in control
+		th.lastSwitch = time.Now().Add(-time.Hour)
+		sensor.setValue(30)
+		th.Run()
+		if th.Failsafe.Active || !th.Failsafe.Since.IsZero() {
+			t.Errorf("%s: failsafe still active after recovery", test.mode)
+		}
+		if th.IsOn {
+			t.Errorf("%s: thermostat should be off above setpoint after recovery", test.mode)
+		}
+	}
+}
+
+func TestThermoFailsafeMaxAge(t *testing.T) {
+	th := newTestThermo(t, 46, 20, &Thermo{Setpoint: 25, Failsafe: &Failsafe{Mode: FailsafeOn, MaxAgeSeconds: 60}})
+	th.lastSwitch = time.Now().Add(-time.Hour)
+	th.Sensor.LastGood = time.Now().Add(-30 * time.Second)
+	th.Sensor.Status = SlaveError
+	th.Run()
+	if th.Failsafe.Active {
+		t.Error("failsafe active before MaxAgeSeconds")
+	}
+
+	th.Sensor.LastGood = time.Now().Add(-61 * time.Second)
+	th.Run()
+	if !th.Failsafe.Active || !th.IsOn {
+		t.Errorf("expected failsafe on after MaxAgeSeconds (active: %v, on: %v)", th.Failsafe.Active, th.IsOn)
+	}
+}
+
+func TestThermoBoost(t *testing.T) {
+	// sensor above heat up setpoint
+	th := newTestThermo(t, 47, 40, &Thermo{Setpoint: 30, HeatUp: 5})
+
+	// without time limit and target boost lasts until cancelled (/heatup/on)
+	th.StartBoost(0, false)
+	if !th.CheckBoost() || !th.HeatUpMode {
+		t.Fatal("open-ended boost should stay active above target")
+	}
+	th.CancelBoost()
+	if th.Boost != nil || th.HeatUpMode {
+		t.Error("boost should be cancelled")
+	}
+
+	th.StartBoost(0, true)
+	if th.Boost != nil {
+		t.Error("boost until target should end at once above target")
+	}
+
+	th.StartBoost(time.Minute, false)
+	if !th.CheckBoost() || th.Boost.RemainingSeconds <= 0 {
+		t.Fatal("timed boost should be active with remaining time")
+	}
+	th.Boost.Until = time.Now().Add(-time.Second)
+	if th.CheckBoost() {
+		t.Error("timed boost should end after Until")
+	}
+}
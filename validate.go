@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfigError is a single config problem with JSON path of the value, e.g. $.Sensors[0].Thermostat.Gpio
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+func (ce ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", ce.Path, ce.Message)
+}
+
+// ConfigErrors is a list of all problems found in config.
+type ConfigErrors []ConfigError
+
+func (ces ConfigErrors) Error() string {
+	lines := make([]string, len(ces))
+	for ix, ce := range ces {
+		lines[ix] = ce.Error()
+	}
+
+	return fmt.Sprintf("config has %d error(s):\n%s", len(ces), strings.Join(lines, "\n"))
+}
+
+func (ces *ConfigErrors) add(path string, format string, args ...interface{}) {
+	*ces = append(*ces, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// checkConfigFields compares decoded json document with config type, unknown fields and values
+// of wrong type are reported (encoding/json silently ignores unknown fields).
+func checkConfigFields(value interface{}, t reflect.Type, path string) (errs ConfigErrors) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if value == nil {
+		return
+	}
+
+	if t == reflect.TypeOf(time.Time{}) {
+		if _, ok := value.(string); !ok {
+			errs.add(path, "expected time string, got %s", getJsonKind(value))
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "expected object, got %s", getJsonKind(value))
+			return
+		}
+		fields := getJsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, found := fields[strings.ToLower(key)]
+			if !found {
+				errs.add(path+"."+key, "unknown field%s", suggestField(key, fields))
+				continue
+			}
+			errs = append(errs, checkConfigFields(obj[key], field.Type, path+"."+key)...)
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			errs.add(path, "expected array, got %s", getJsonKind(value))
+			return
+		}
+		for ix, elem := range list {
+			errs = append(errs, checkConfigFields(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, ix))...)
+		}
+	case reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "expected object, got %s", getJsonKind(value))
+			return
+		}
+		for key, elem := range obj {
+			errs = append(errs, checkConfigFields(elem, t.Elem(), path+"."+key)...)
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			errs.add(path, "expected string, got %s", getJsonKind(value))
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			errs.add(path, "expected bool, got %s", getJsonKind(value))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(float64)
+		if !ok {
+			errs.add(path, "expected integer, got %s", getJsonKind(value))
+		} else if number != float64(int64(number)) {
+			errs.add(path, "expected integer, got %v", number)
+		} else if number < 0 && t.Kind() >= reflect.Uint {
+			errs.add(path, "expected positive integer, got %v", number)
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(float64); !ok {
+			errs.add(path, "expected number, got %s", getJsonKind(value))
+		}
+	}
+
+	return
+}
+
+// getJsonFields returns exported fields of struct by lowercase json name (encoding/json matches case insensitive).
+func getJsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for ix := 0; ix < t.NumField(); ix++ {
+		field := t.Field(ix)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field
+	}
+
+	return fields
+}
+
+func getJsonKind(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	default:
+		return "null"
+	}
+}
+
+func suggestField(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for name, field := range fields {
+		distance := levenshtein(strings.ToLower(key), name)
+		if distance < bestDistance {
+			best, bestDistance = field.Name, distance
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+func levenshtein(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		diagonal := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			next := diagonal + cost
+			if row[j]+1 < next {
+				next = row[j] + 1
+			}
+			if row[j-1]+1 < next {
+				next = row[j-1] + 1
+			}
+			diagonal, row[j] = row[j], next
+		}
+	}
+
+	return row[len(b)]
+}
+
+func checkUrl(errs *ConfigErrors, path string, rawUrl string) {
+	if len(rawUrl) == 0 {
+		errs.add(path, "missing url")
+		return
+	}
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		errs.add(path, "bad url (%s): %v", rawUrl, err)
+		return
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		errs.add(path, "bad url (%s): expected http or https scheme", rawUrl)
+	} else if len(parsed.Host) == 0 {
+		errs.add(path, "bad url (%s): missing host", rawUrl)
+	}
+}
+
+func checkHostPort(errs *ConfigErrors, path string, hostPort string) {
+	_, port, err := net.SplitHostPort(hostPort)
+	if err != nil || len(port) == 0 {
+		errs.add(path, "expected host:port, got (%s)", hostPort)
+	}
+}
+
+// Validate checks parsed config: duplicated sensors, gpio conflicts, setpoints and urls.
+func (os *OwSet) Validate() (errs ConfigErrors) {
+	names := map[string]int{}
+	ids := map[string]int{}
+	outputs := map[string]string{}
+
+	for ix, slave := range os.Sensors {
+		path := fmt.Sprintf("$.Sensors[%d]", ix)
+		if len(slave.Name) > 0 {
+			if first, found := names[slave.Name]; found {
+				errs.add(path+".Name", "duplicated sensor name (%s), first used in $.Sensors[%d]", slave.Name, first)
+			} else {
+				names[slave.Name] = ix
+			}
+		}
+		if slave.Id != 0 {
+			id := fmt.Sprintf("%s-%012x", slave.Family, slave.Id)
+			if first, found := ids[id]; found {
+				errs.add(path+".Id", "duplicated sensor id (%s), first used in $.Sensors[%d]", id, first)
+			} else {
+				ids[id] = ix
+			}
+		}
+
+		th := slave.Thermostat
+		if th == nil {
+			continue
+		}
+		path += ".Thermostat"
+
+		if len(th.Stages) == 0 {
+			checkOutputUse(&errs, outputs, path, "Gpio", th.Output, th.Gpio)
+		}
+		if th.ChangeoverGpio != 0 {
+			checkOutputUse(&errs, outputs, path, "ChangeoverGpio", th.Output, th.ChangeoverGpio)
+		}
+		for ix, stage := range th.Stages {
+			checkOutputUse(&errs, outputs, fmt.Sprintf("%s.Stages[%d]", path, ix), "Gpio", stage.Output, stage.Gpio)
+		}
+
+		if th.Feedback != nil && th.Feedback.Driver != OutputFake {
+			key := "input:" + th.Feedback.getInputConfig().getKey(th.Feedback.Gpio)
+			if first, found := outputs[key]; found {
+				errs.add(path+".Feedback.Gpio", "gpio %d already used by %s", th.Feedback.Gpio, first)
+			} else if first, found := outputs[th.Feedback.getInputConfig().getKey(th.Feedback.Gpio)]; found {
+				errs.add(path+".Feedback.Gpio", "gpio %d already used by %s", th.Feedback.Gpio, first)
+			} else {
+				outputs[key] = path + ".Feedback.Gpio"
+			}
+		}
+
+		// setpoints are checked only against Min and Max set in config (not default Max)
+		if th.Min != 0 && !th.defaultMax && th.Min >= th.Max {
+			errs.add(path, "Min (%v) should be lower than Max (%v)", th.Min, th.Max)
+		}
+		if th.Min != 0 && th.Setpoint < th.Min {
+			errs.add(path+".Setpoint", "Setpoint (%v) below Min (%v)", th.Setpoint, th.Min)
+		}
+		if !th.defaultMax && th.Setpoint > th.Max {
+			errs.add(path+".Setpoint", "Setpoint (%v) above Max (%v)", th.Setpoint, th.Max)
+		}
+		if th.Mode == ModeAuto && !th.defaultMax && th.CoolSetpoint > th.Max {
+			errs.add(path+".CoolSetpoint", "CoolSetpoint (%v) above Max (%v)", th.CoolSetpoint, th.Max)
+		}
+		if th.Hysteresis < 0 {
+			errs.add(path+".Hysteresis", "Hysteresis (%v) should not be negative", th.Hysteresis)
+		}
+		if th.HeatUp < 0 {
+			errs.add(path+".HeatUp", "HeatUp (%v) should not be negative", th.HeatUp)
+		}
+	}
+
+	for ix, slave := range os.Sensors {
+		if slave.Virtual == nil {
+			continue
+		}
+		for _, name := range slave.Virtual.Sensors {
+			first, found := names[name]
+			switch {
+			case !found:
+				errs.add(fmt.Sprintf("$.Sensors[%d].Virtual", ix), "sensor %s not found (sensors are referenced by Name)", name)
+			case first >= ix && os.Sensors[first].Virtual != nil:
+				// physical sensors are read before all virtual ones
+				errs.add(fmt.Sprintf("$.Sensors[%d].Virtual", ix), "virtual sensor %s should be defined before this one (virtual sensors are computed in config order)", name)
+			}
+		}
+	}
+
+	for ix, slave := range os.Sensors {
+		if slave.Thermostat == nil || slave.Thermostat.Aggregate == nil {
+			continue
+		}
+		for sx, name := range slave.Thermostat.Aggregate.Sensors {
+			if _, found := names[name]; !found {
+				errs.add(fmt.Sprintf("$.Sensors[%d].Thermostat.Aggregate.Sensors[%d]", ix, sx), "sensor %s not found (sensors are referenced by Name)", name)
+			}
+		}
+	}
+
+	if os.LogInflux != nil {
+		checkUrl(&errs, "$.LogInflux.Host", os.LogInflux.Host)
+		if len(os.LogInflux.Measurment) == 0 {
+			errs.add("$.LogInflux.Measurment", "missing measurement name")
+		}
+	}
+	if os.SendHttp != nil {
+		checkUrl(&errs, "$.SendHttp.Host", os.SendHttp.Host)
+		if os.SendHttp.Method != "GET" && os.SendHttp.Method != "POST" {
+			errs.add("$.SendHttp.Method", "unsupported method (%s), expected GET or POST", os.SendHttp.Method)
+		}
+	}
+	if os.OffPeak != nil {
+		checkUrl(&errs, "$.OffPeak.Url", os.OffPeak.Url)
+	}
+	if os.EnergyPanel != nil {
+		checkHostPort(&errs, "$.EnergyPanel.ConnectionString", os.EnergyPanel.ConnectionString)
+	}
+	if os.OwServer != nil {
+		checkHostPort(&errs, "$.OwServer.Host", os.OwServer.Host)
+	}
+	if os.Server != nil && (os.Server.Port == 0 || os.Server.Port > 65535) {
+		errs.add("$.Server.Port", "port (%d) out of 1-65535 range", os.Server.Port)
+	}
+
+	return
+}
+
+// checkOutputUse checks gpio range or remote relay host and reports output used more than once
+// (used is map of output keys to config path).
+func checkOutputUse(errs *ConfigErrors, used map[string]string, path string, field string, oc *OutputConfig, gpio int) {
+	key := oc.getKey(gpio)
+	if oc.IsGpio() {
+		path += "." + field
+		if oc.Driver == OutputGpiod && gpio < 1 {
+			errs.add(path, "gpio (%d) should be positive", gpio)
+		} else if oc.Driver != OutputGpiod && (gpio < 1 || gpio > 27) {
+			errs.add(path, "gpio (%d) out of 1-27 range", gpio)
+		}
+		if first, found := used["input:"+key]; found {
+			errs.add(path, "gpio %d already used by %s", gpio, first)
+			return
+		}
+	} else {
+		path += ".Output"
+		if oc.Driver == OutputModbus {
+			checkHostPort(errs, path+".Host", oc.Host)
+		} else {
+			checkUrl(errs, path+".Host", oc.Host)
+		}
+	}
+
+	if first, found := used[key]; found && oc.IsGpio() {
+		errs.add(path, "gpio %d already used by %s", gpio, first)
+	} else if found {
+		errs.add(path, "relay (%s/%d) already used by %s", oc.Host, oc.Channel, first)
+	} else {
+		used[key] = path
+	}
+}
+
+// checkConfigDocument checks config document for json syntax, unknown fields and values of wrong type.
+func checkConfigDocument(configFile []byte) (errs ConfigErrors) {
+	var doc interface{}
+	err := json.Unmarshal(configFile, &doc)
+	if err != nil {
+		errs.add("$", "json parsing failed: %v", err)
+		return
+	}
+
+	return checkConfigFields(doc, reflect.TypeOf(OwSet{}), "$")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSetpointRange(t *testing.T) {
+	tests := []struct {
+		name, thermo, err string
+	}{
+		{"boiler without Max", `"Gpio": 21, "Setpoint": 60`, ""},
+		{"freezer without Min", `"Gpio": 21, "Setpoint": -18, "Mode": "cool"`, ""},
+		{"above Max", `"Gpio": 21, "Setpoint": 60, "Max": 55`, "Setpoint (60) above Max (55)"},
+		{"below Min", `"Gpio": 21, "Setpoint": 3, "Min": 5`, "Setpoint (3) below Min (5)"},
+		{"Min above Max", `"Gpio": 21, "Setpoint": 20, "Min": 30, "Max": 25`, "Min (30) should be lower than Max (25)"},
+		{"stage gpio zero", `"Stages": [{"Gpio": 0}], "Setpoint": 20`, "gpio (0) out of 1-27 range"},
+		{"gpio above 27", `"Gpio": 28, "Setpoint": 20`, "gpio (28) out of 1-27 range"},
+	}
+
+	for _, test := range tests {
+		wires := &OwSet{}
+		err := wires.parseConfig([]byte(`{"Sensors": [{"Name": "tank", "HexId": "28-0316a27955ff",
+			"Thermostat": {` + test.thermo + `, "Output": {"Driver": "fake"}}}]}`))
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestThermoHeatUpMax(t *testing.T) {
+	tests := []struct {
+		name                  string
+		setpoint, heatUp, max float64
+		expected              float64
+	}{
+		{"default Max caps heat up", 38, 8, 0, 40},
+		{"below default Max", 30, 8, 0, 38},
+		{"setpoint above default Max is not lowered", 60, 5, 0, 60},
+		{"explicit Max", 60, 5, 62, 62},
+	}
+
+	for _, test := range tests {
+		th := &Thermo{Gpio: 21, Setpoint: test.setpoint, HeatUp: test.heatUp, Max: test.max, Output: &OutputConfig{Driver: OutputFake}}
+		err := (&OwSlave{Name: "tank", Thermostat: th}).InitThermo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if setpoint := th.getSetpoint(true); setpoint != test.expected {
+			t.Errorf("%s: expected heat up setpoint %v, got %v", test.name, test.expected, setpoint)
+		}
+	}
+}
+
+func TestValidateStagedExample(t *testing.T) {
+	// staged thermostat from README (boiler setpoint, no Max)
+	wires := &OwSet{}
+	err := wires.parseConfig([]byte(`{"Sensors": [{"Name": "boiler", "HexId": "28-0316a27955ff", "Thermostat": {
+		"Setpoint": 60,
+		"Rotate": true,
+		"Stages": [
+			{"Gpio": 5},
+			{"Gpio": 6, "Offset": 3},
+			{"Gpio": 13, "Offset": 6}
+		],
+		"Output": {"Driver": "fake"}
+	}}]}`))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateVirtualOrder(t *testing.T) {
+	tests := []struct {
+		name, sensors, err string
+	}{
+		{"physical sensor defined after virtual", `
+			{"Name": "delta", "Virtual": {"Function": "difference", "Sensors": ["supply", "return"]}},
+			{"Name": "supply", "HexId": "28-0316a27955ff"},
+			{"Name": "return", "HexId": "28-0316a27955aa"}`, ""},
+		{"virtual sensor defined before", `
+			{"Name": "supply", "HexId": "28-0316a27955ff"},
+			{"Name": "trend", "Virtual": {"Function": "rate", "Sensors": ["supply"]}},
+			{"Name": "trend-mean", "Virtual": {"Function": "rolling-mean", "Sensors": ["trend"]}}`, ""},
+		{"virtual sensor defined after", `
+			{"Name": "trend-mean", "Virtual": {"Function": "rolling-mean", "Sensors": ["trend"]}},
+			{"Name": "trend", "Virtual": {"Function": "rate", "Sensors": ["supply"]}},
+			{"Name": "supply", "HexId": "28-0316a27955ff"}`,
+			"$.Sensors[0].Virtual: virtual sensor trend should be defined before this one"},
+		{"itself", `
+			{"Name": "loop", "Virtual": {"Expression": "loop + 1"}}`,
+			"$.Sensors[0].Virtual: virtual sensor loop should be defined before this one"},
+	}
+
+	for _, test := range tests {
+		wires := &OwSet{}
+		err := wires.parseConfig([]byte(`{"Sensors": [` + test.sensors + `]}`))
+		if len(test.err) == 0 && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q, got: %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	VirtualDifference  = "difference"
+	VirtualAverage     = "average"
+	VirtualRollingMean = "rolling-mean"
+	VirtualMin         = "min"
+	VirtualMax         = "max"
+	VirtualRate        = "rate"
+	VirtualExpression  = "expression"
+)
+
+// Virtual defines computed sensor: built-in Function over other Sensors (by name) or Expression.
+// Functions: difference (first minus second), average, rolling-mean (of first sensor over
+// WindowMinutes), min and max (of all sensors, over WindowMinutes if set), rate (change of first
+// sensor in °C/h over WindowMinutes). Expression uses sensor names (in braces if name is not
+// a plain identifier, e.g. {supply-1}), numbers, + - * / and abs, min, max functions.
+type Virtual struct {
+	Function      string
+	Sensors       []string `json:",omitempty"`
+	Expression    string   `json:",omitempty"`
+	WindowMinutes int      `json:",omitempty"`
+
+	expr    exprNode
+	samples []virtualSample
+}
+
+// ErrVirtualNotReady is returned (wrapped) by Compute when windowed function has not enough samples
+// yet (rate on first cycle), it is not a sensor failure.
+var ErrVirtualNotReady = errors.New("not enough samples yet")
+
+type virtualSample struct {
+	when  time.Time
+	value float64
+}
+
+// Init checks config and sets defaults.
+func (vs *Virtual) Init() (err error) {
+	switch vs.Function {
+	case "":
+		if len(vs.Expression) == 0 {
+			return fmt.Errorf("Virtual Init: missing Function or Expression")
+		}
+		vs.Function = VirtualExpression
+		fallthrough
+	case VirtualExpression:
+		vs.expr, err = parseExpression(vs.Expression)
+		if err != nil {
+			return fmt.Errorf("Virtual Init: bad expression (%s):\n%w", vs.Expression, err)
+		}
+		vs.Sensors = vs.expr.names(nil)
+		return nil
+	case VirtualDifference:
+		if len(vs.Sensors) != 2 {
+			return fmt.Errorf("Virtual Init: difference requires 2 Sensors, got %d", len(vs.Sensors))
+		}
+	case VirtualAverage, VirtualMin, VirtualMax:
+		if len(vs.Sensors) == 0 {
+			return fmt.Errorf("Virtual Init: no Sensors")
+		}
+	case VirtualRollingMean, VirtualRate:
+		if len(vs.Sensors) != 1 {
+			return fmt.Errorf("Virtual Init: %s requires 1 sensor, got %d", vs.Function, len(vs.Sensors))
+		}
+		if vs.WindowMinutes == 0 {
+			vs.WindowMinutes = 60
+		}
+	default:
+		return fmt.Errorf("Virtual Init: unknown Function (%s)", vs.Function)
+	}
+	if vs.WindowMinutes < 0 {
+		return fmt.Errorf("Virtual Init: WindowMinutes (%d) should not be negative", vs.WindowMinutes)
+	}
+
+	return nil
+}
+
+// addSample keeps value for windowed functions, samples older than window are removed.
+func (vs *Virtual) addSample(now time.Time, value float64) {
+	vs.samples = append(vs.samples, virtualSample{when: now, value: value})
+	window := time.Duration(vs.WindowMinutes) * time.Minute
+	for len(vs.samples) > 0 && now.Sub(vs.samples[0].when) > window {
+		vs.samples = vs.samples[1:]
+	}
+}
+
+// Compute returns virtual sensor value, find returns sensor by name.
+func (vs *Virtual) Compute(find func(name string) *OwSlave) (float64, error) {
+	values := map[string]float64{}
+	for _, name := range vs.Sensors {
+		slave := find(name)
+		if slave == nil {
+			return 0, fmt.Errorf("sensor %s not found", name)
+		}
+		if !slave.IsOk() {
+			return 0, fmt.Errorf("sensor %s status is %s", name, slave.Status)
+		}
+		values[name] = slave.Value
+	}
+
+	now := time.Now()
+	switch vs.Function {
+	case VirtualExpression:
+		return vs.expr.eval(values)
+	case VirtualDifference:
+		return values[vs.Sensors[0]] - values[vs.Sensors[1]], nil
+	case VirtualAverage:
+		var sum float64
+		for _, name := range vs.Sensors {
+			sum += values[name]
+		}
+		return sum / float64(len(vs.Sensors)), nil
+	case VirtualMin, VirtualMax:
+		for _, name := range vs.Sensors {
+			vs.addSample(now, values[name])
+		}
+		result := vs.samples[0].value
+		for _, sample := range vs.samples {
+			if (vs.Function == VirtualMin && sample.value < result) || (vs.Function == VirtualMax && sample.value > result) {
+				result = sample.value
+			}
+		}
+		return result, nil
+	case VirtualRollingMean:
+		vs.addSample(now, values[vs.Sensors[0]])
+		var sum float64
+		for _, sample := range vs.samples {
+			sum += sample.value
+		}
+		return sum / float64(len(vs.samples)), nil
+	case VirtualRate:
+		vs.addSample(now, values[vs.Sensors[0]])
+		first, last := vs.samples[0], vs.samples[len(vs.samples)-1]
+		hours := last.when.Sub(first.when).Hours()
+		if hours == 0 {
+			return 0, fmt.Errorf("rate: %w", ErrVirtualNotReady)
+		}
+		return (last.value - first.value) / hours, nil
+	}
+
+	return 0, fmt.Errorf("unknown function (%s)", vs.Function)
+}
+
+// refreshVirtuals computes virtual sensors (in config order, virtual sensor can use virtual ones defined before it).
+// Virtual sensor without enough samples yet is left as it is (not ready, not failed).
+func (os *OwSet) refreshVirtuals() (failed []string) {
+	for _, slave := range os.Sensors {
+		if slave.Virtual == nil {
+			continue
+		}
+		value, err := slave.Virtual.Compute(os.GetSlaveByName)
+		if errors.Is(err, ErrVirtualNotReady) {
+			continue
+		}
+		if err != nil {
+			slave.SetFailed(SlaveError, err, os.staleAfter())
+			failed = append(failed, fmt.Sprintf("virtual sensor (%s) failed: %v", slave.Name, err))
+			continue
+		}
+		slave.setValue(value)
+	}
+
+	return
+}
+
+// exprNode is parsed expression of virtual sensor.
+type exprNode interface {
+	eval(values map[string]float64) (float64, error)
+	names(list []string) []string
+}
+
+type exprNumber float64
+
+type exprSensor string
+
+type exprBinary struct {
+	op          byte
+	left, right exprNode
+}
+
+type exprCall struct {
+	function string
+	args     []exprNode
+}
+
+func (en exprNumber) eval(values map[string]float64) (float64, error) {
+	return float64(en), nil
+}
+
+func (en exprNumber) names(list []string) []string {
+	return list
+}
+
+func (es exprSensor) eval(values map[string]float64) (float64, error) {
+	return values[string(es)], nil
+}
+
+func (es exprSensor) names(list []string) []string {
+	if containsString(list, string(es)) {
+		return list
+	}
+
+	return append(list, string(es))
+}
+
+func (eb *exprBinary) eval(values map[string]float64) (float64, error) {
+	left, err := eb.left.eval(values)
+	if err != nil {
+		return 0, err
+	}
+	right, err := eb.right.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch eb.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	default:
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return left / right, nil
+	}
+}
+
+func (eb *exprBinary) names(list []string) []string {
+	return eb.right.names(eb.left.names(list))
+}
+
+func (ec *exprCall) eval(values map[string]float64) (result float64, err error) {
+	args := make([]float64, len(ec.args))
+	for ix, arg := range ec.args {
+		args[ix], err = arg.eval(values)
+		if err != nil {
+			return
+		}
+	}
+
+	result = args[0]
+	switch ec.function {
+	case "abs":
+		return math.Abs(result), nil
+	case "min":
+		for _, arg := range args {
+			result = math.Min(result, arg)
+		}
+	case "max":
+		for _, arg := range args {
+			result = math.Max(result, arg)
+		}
+	}
+
+	return
+}
+
+func (ec *exprCall) names(list []string) []string {
+	for _, arg := range ec.args {
+		list = arg.names(list)
+	}
+
+	return list
+}
+
+// exprParser is recursive descent parser of virtual sensor expression.
+type exprParser struct {
+	input string
+	pos   int
+}
+
+func parseExpression(input string) (exprNode, error) {
+	parser := &exprParser{input: input}
+	node, err := parser.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	parser.skipSpaces()
+	if parser.pos < len(parser.input) {
+		return nil, fmt.Errorf("unexpected %q at %d", parser.input[parser.pos:], parser.pos)
+	}
+
+	return node, nil
+}
+
+func (ep *exprParser) skipSpaces() {
+	for ep.pos < len(ep.input) && ep.input[ep.pos] == ' ' {
+		ep.pos++
+	}
+}
+
+// peek returns next non space character (0 at the end).
+func (ep *exprParser) peek() byte {
+	ep.skipSpaces()
+	if ep.pos < len(ep.input) {
+		return ep.input[ep.pos]
+	}
+
+	return 0
+}
+
+func (ep *exprParser) parseSum() (exprNode, error) {
+	node, err := ep.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := ep.peek(); op == '+' || op == '-'; op = ep.peek() {
+		ep.pos++
+		right, err := ep.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		node = &exprBinary{op: op, left: node, right: right}
+	}
+
+	return node, nil
+}
+
+func (ep *exprParser) parseProduct() (exprNode, error) {
+	node, err := ep.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := ep.peek(); op == '*' || op == '/'; op = ep.peek() {
+		ep.pos++
+		right, err := ep.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		node = &exprBinary{op: op, left: node, right: right}
+	}
+
+	return node, nil
+}
+
+func (ep *exprParser) parseUnary() (exprNode, error) {
+	if ep.peek() == '-' {
+		ep.pos++
+		node, err := ep.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprBinary{op: '-', left: exprNumber(0), right: node}, nil
+	}
+
+	return ep.parseTerm()
+}
+
+func (ep *exprParser) parseTerm() (exprNode, error) {
+	next := ep.peek()
+	switch {
+	case next == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case next == '(':
+		ep.pos++
+		node, err := ep.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if ep.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", ep.pos)
+		}
+		ep.pos++
+		return node, nil
+	case next == '{':
+		end := strings.IndexByte(ep.input[ep.pos:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("missing } at %d", ep.pos)
+		}
+		name := ep.input[ep.pos+1 : ep.pos+end]
+		ep.pos += end + 1
+		return exprSensor(name), nil
+	case next == '.' || unicode.IsDigit(rune(next)):
+		start := ep.pos
+		for ep.pos < len(ep.input) && (ep.input[ep.pos] == '.' || unicode.IsDigit(rune(ep.input[ep.pos]))) {
+			ep.pos++
+		}
+		number, err := strconv.ParseFloat(ep.input[start:ep.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number at %d: %w", start, err)
+		}
+		return exprNumber(number), nil
+	case next == '_' || unicode.IsLetter(rune(next)):
+		start := ep.pos
+		for ep.pos < len(ep.input) && (ep.input[ep.pos] == '_' || unicode.IsLetter(rune(ep.input[ep.pos])) || unicode.IsDigit(rune(ep.input[ep.pos]))) {
+			ep.pos++
+		}
+		name := ep.input[start:ep.pos]
+		if ep.peek() != '(' {
+			return exprSensor(name), nil
+		}
+		return ep.parseCall(name)
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", next, ep.pos)
+}
+
+func (ep *exprParser) parseCall(function string) (exprNode, error) {
+	switch function {
+	case "abs", "min", "max":
+	default:
+		return nil, fmt.Errorf("unknown function %s", function)
+	}
+
+	ep.pos++
+	call := &exprCall{function: function}
+	for {
+		arg, err := ep.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		next := ep.peek()
+		ep.pos++
+		if next == ')' {
+			break
+		}
+		if next != ',' {
+			return nil, fmt.Errorf("expected , or ) at %d", ep.pos-1)
+		}
+	}
+	if function == "abs" && len(call.args) != 1 {
+		return nil, fmt.Errorf("abs takes 1 argument, got %d", len(call.args))
+	}
+
+	return call, nil
+}
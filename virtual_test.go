@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSensors returns sensors with given values and find function for Virtual Compute.
+func newTestSensors(values map[string]float64) func(name string) *OwSlave {
+	sensors := map[string]*OwSlave{}
+	for name, value := range values {
+		sensors[name] = &OwSlave{Name: name}
+		sensors[name].setValue(value)
+	}
+
+	return func(name string) *OwSlave {
+		return sensors[name]
+	}
+}
+
+func TestExpression(t *testing.T) {
+	values := map[string]float64{"supply": 45, "return": 35, "t_out": -5}
+	tests := []struct {
+		expression string
+		expected   float64
+		err        string
+	}{
+		{"1 + 2 * 3", 7, ""},
+		{"(1 + 2) * 3", 9, ""},
+		{"10 - 4 - 3", 3, ""},
+		{"8 / 4 / 2", 1, ""},
+		{"2 * 3 - 8 / 4", 4, ""},
+		{"-2 * 3", -6, ""},
+		{"--2", 2, ""},
+		{"1.5+.5", 2, ""},
+		{"supply - {return}", 10, ""},
+		{"(supply + {return}) / 2 - 0.5", 39.5, ""},
+		{"abs(t_out)", 5, ""},
+		{"min(supply, return, 40)", 35, ""},
+		{"max(t_out, 0) * 2", 0, ""},
+		{"supply / (return - 35)", 0, "division by zero"},
+		{"1 / 0", 0, "division by zero"},
+		{"", 0, "unexpected end of expression"},
+		{"1 +", 0, "unexpected end of expression"},
+		{"(1 + 2", 0, "missing )"},
+		{"{supply + 1", 0, "missing }"},
+		{"1 2", 0, `unexpected "2" at 2`},
+		{"sqrt(4)", 0, "unknown function sqrt"},
+		{"abs(1, 2)", 0, "abs takes 1 argument, got 2"},
+		{"max(1; 2)", 0, "expected , or )"},
+		{"1..2", 0, "bad number at 0"},
+		{"2 % 1", 0, `unexpected "% 1" at 2`},
+	}
+
+	for _, test := range tests {
+		var result float64
+		expr, err := parseExpression(test.expression)
+		if err == nil {
+			result, err = expr.eval(values)
+		}
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: expected error %q, got: %v (result %v)", test.expression, test.err, err, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.expression, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.expression, test.expected, result)
+		}
+	}
+}
+
+func TestExpressionSensors(t *testing.T) {
+	vs := &Virtual{Expression: "(supply + {return}) / 2 - supply * {hall-2}"}
+	err := vs.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"supply", "return", "hall-2"}; !reflect.DeepEqual(vs.Sensors, expected) {
+		t.Errorf("expected sensors %v, got %v", expected, vs.Sensors)
+	}
+
+	_, err = vs.Compute(newTestSensors(map[string]float64{"supply": 45, "return": 35}))
+	if err == nil || !strings.Contains(err.Error(), "sensor hall-2 not found") {
+		t.Errorf("expected missing sensor error, got: %v", err)
+	}
+}
+
+func TestVirtualWindow(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		function string
+		window   int
+		samples  []float64 // taken 25, 15 and 5 minutes ago
+		current  []float64
+		expected float64
+	}{
+		{VirtualRollingMean, 20, []float64{100, 20, 40}, []float64{30}, 30},
+		{VirtualRollingMean, 60, []float64{100, 20, 40}, []float64{30}, 47.5},
+		{VirtualMin, 10, []float64{10, 15, 25}, []float64{30, 28}, 25},
+		{VirtualMax, 20, []float64{90, 15, 25}, []float64{30, 28}, 30},
+		// without window only current values
+		{VirtualMin, 0, []float64{10, 15, 25}, []float64{30, 28}, 28},
+		{VirtualMax, 0, []float64{90, 15, 25}, []float64{27, 28}, 28},
+	}
+
+	for _, test := range tests {
+		name := fmt.Sprintf("%s over %d minutes", test.function, test.window)
+		vs := &Virtual{Function: test.function, WindowMinutes: test.window}
+		values := map[string]float64{}
+		for ix, value := range test.current {
+			sensor := fmt.Sprintf("sensor-%d", ix)
+			vs.Sensors = append(vs.Sensors, sensor)
+			values[sensor] = value
+		}
+		err := vs.Init()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		for ix, value := range test.samples {
+			vs.samples = append(vs.samples, virtualSample{when: now.Add(time.Duration(ix*10-25) * time.Minute), value: value})
+		}
+
+		result, err := vs.Compute(newTestSensors(values))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if result != test.expected {
+			t.Errorf("%s: expected %v, got %v", name, test.expected, result)
+		}
+		for _, sample := range vs.samples {
+			if sample.when.Before(now.Add(-time.Duration(test.window) * time.Minute)) {
+				t.Errorf("%s: sample from %s ago should be removed", name, now.Sub(sample.when).Round(time.Minute))
+			}
+		}
+	}
+}
+
+func TestVirtualRate(t *testing.T) {
+	vs := &Virtual{Function: VirtualRate, Sensors: []string{"supply"}, WindowMinutes: 30}
+	err := vs.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = vs.Compute(newTestSensors(map[string]float64{"supply": 40}))
+	if !errors.Is(err, ErrVirtualNotReady) {
+		t.Fatalf("expected not ready on first sample, got: %v", err)
+	}
+
+	// first sample 20 minutes ago, older one is out of window
+	vs.samples[0].when = time.Now().Add(-20 * time.Minute)
+	vs.samples = append([]virtualSample{{when: time.Now().Add(-40 * time.Minute), value: 0}}, vs.samples...)
+	rate, err := vs.Compute(newTestSensors(map[string]float64{"supply": 45}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isClose(rate, 15) {
+		t.Errorf("expected rate 15 °C/h, got %v", rate)
+	}
+}
+
+func TestRefreshVirtualsNotReady(t *testing.T) {
+	wires := &OwSet{}
+	err := wires.parseConfig([]byte(`{"Sensors": [
+		{"Name": "supply", "HexId": "28-0316a27955ff"},
+		{"Name": "supply-trend", "Virtual": {"Function": "rate", "Sensors": ["supply"]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	supply, trend := wires.GetSlaveByName("supply"), wires.GetSlaveByName("supply-trend")
+
+	supply.setValue(40)
+	failed := wires.refreshVirtuals()
+	if len(failed) > 0 || trend.Failures > 0 || len(trend.LastError) > 0 || trend.IsOk() {
+		t.Errorf("rate without samples should not be ready nor failed, got %v (status %q, failures %d)", failed, trend.Status, trend.Failures)
+	}
+
+	// input sensor failure is virtual sensor failure
+	supply.SetFailed(SlaveError, fmt.Errorf("read failed"), time.Minute)
+	failed = wires.refreshVirtuals()
+	if len(failed) != 1 || trend.Failures != 1 || trend.IsOk() {
+		t.Errorf("expected virtual sensor failure, got %v (status %q, failures %d)", failed, trend.Status, trend.Failures)
+	}
+}
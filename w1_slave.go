@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// owFamily describes 1-wire temperature sensor family.
+type owFamily struct {
+	Code string
+	Name string
+
+	// convert returns temperature in 1/1000 °C calculated from scratchpad bytes
+	convert func(scratchpad []byte) int64
+}
+
+var owFamilies = []owFamily{
+	{Code: "10", Name: "DS18S20", convert: convertDS18S20},
+	{Code: "22", Name: "DS1822", convert: convertDS18B20},
+	{Code: "28", Name: "DS18B20", convert: convertDS18B20},
+	{Code: "3b", Name: "DS1825", convert: convertDS18B20},
+	{Code: "42", Name: "DS28EA00", convert: convertDS18B20},
+}
+
+func getOwFamily(code string) *owFamily {
+	code = strings.ToLower(code)
+	for ix := range owFamilies {
+		if owFamilies[ix].Code == code {
+			return &owFamilies[ix]
+		}
+	}
+
+	return nil
+}
+
+// convertDS18B20 converts 12-bit (or less, depending on configuration register) two's complement value, 1/16 °C per bit.
+func convertDS18B20(scratchpad []byte) int64 {
+	raw := int16(uint16(scratchpad[1])<<8 | uint16(scratchpad[0]))
+
+	// undefined low bits for 9, 10 and 11 bit resolution
+	resolution := (scratchpad[4] >> 5) & 0x03
+	raw &^= int16(1<<(3-resolution)) - 1
+
+	return int64(raw) * 1000 / 16
+}
+
+// convertDS18S20 converts 9-bit value (0.5 °C per bit) extended with COUNT_REMAIN and COUNT_PER_C registers.
+func convertDS18S20(scratchpad []byte) int64 {
+	raw := int16(uint16(scratchpad[1])<<8 | uint16(scratchpad[0]))
+	countRemain, countPerC := int64(scratchpad[6]), int64(scratchpad[7])
+	if countPerC == 0 {
+		return int64(raw) * 500
+	}
+
+	return int64(raw>>1)*1000 - 250 + 1000*(countPerC-countRemain)/countPerC
+}
+
+// parseW1Slave parses content of w1_slave file, e.g.:
+//
+//	72 01 4b 46 7f ff 0e 10 57 : crc=57 YES
+//	72 01 4b 46 7f ff 0e 10 57 t=23125
+//
+// Value is calculated from scratchpad bytes with family specific scaling,
+// if scratchpad is missing or family is unknown value after t= is used.
+func parseW1Slave(family string, content []byte) (readout BusReadout, err error) {
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) < 2 {
+		return readout, fmt.Errorf("parseW1Slave: expected two lines, got %d", len(lines))
+	}
+
+	crcLine := strings.SplitN(lines[0], ":", 2)
+	if len(crcLine) != 2 || !strings.Contains(crcLine[1], "crc=") {
+		return readout, fmt.Errorf("parseW1Slave: crc not found in first line (%s)", lines[0])
+	}
+	readout.CrcOk = strings.HasSuffix(strings.TrimSpace(crcLine[1]), "YES")
+
+	tempSlice := strings.Split(lines[1], "t=")
+	if len(tempSlice) != 2 {
+		return readout, fmt.Errorf("parseW1Slave: t= not found or found multiple times")
+	}
+	tempStr := strings.TrimSpace(tempSlice[1])
+	if len(tempStr) == 0 {
+		return readout, fmt.Errorf("parseW1Slave: empty value")
+	}
+	readout.Value, err = strconv.ParseInt(tempStr, 10, 64)
+	if err != nil {
+		return readout, fmt.Errorf("parseW1Slave: error parsing value (%s):\n%w", tempStr, err)
+	}
+
+	fam := getOwFamily(family)
+	scratchpad, errHex := hex.DecodeString(strings.Join(strings.Fields(crcLine[0]), ""))
+	if fam != nil && errHex == nil && len(scratchpad) == 9 {
+		readout.Value = fam.convert(scratchpad)
+	}
+
+	return readout, nil
+}
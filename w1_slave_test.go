@@ -0,0 +1,164 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseW1Slave(t *testing.T) {
+	tests := []struct {
+		name    string
+		family  string
+		content string
+		value   int64
+		crcOk   bool
+	}{
+		{
+			name:    "DS18B20 positive",
+			family:  "28",
+			content: "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23125\n",
+			value:   23125,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18B20 negative",
+			family:  "28",
+			content: "5e ff 4b 46 7f ff 02 10 c1 : crc=c1 YES\n5e ff 4b 46 7f ff 02 10 c1 t=-10125\n",
+			value:   -10125,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18B20 minimum",
+			family:  "28",
+			content: "90 fc 4b 46 7f ff 10 10 2c : crc=2c YES\n90 fc 4b 46 7f ff 10 10 2c t=-55000\n",
+			value:   -55000,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18B20 power-on value",
+			family:  "28",
+			content: "50 05 4b 46 7f ff 0c 10 1c : crc=1c YES\n50 05 4b 46 7f ff 0c 10 1c t=85000\n",
+			value:   85000,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18B20 9-bit resolution",
+			family:  "28",
+			content: "7f 01 4b 46 1f ff 01 10 8b : crc=8b YES\n7f 01 4b 46 1f ff 01 10 8b t=23500\n",
+			value:   23500,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18B20 10-bit resolution",
+			family:  "28",
+			content: "7f 01 4b 46 3f ff 01 10 28 : crc=28 YES\n7f 01 4b 46 3f ff 01 10 28 t=23750\n",
+			value:   23750,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18B20 11-bit resolution",
+			family:  "28",
+			content: "7f 01 4b 46 5f ff 01 10 c8 : crc=c8 YES\n7f 01 4b 46 5f ff 01 10 c8 t=23875\n",
+			value:   23875,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18B20 11-bit negative",
+			family:  "28",
+			content: "6f fe 4b 46 5f ff 01 10 5d : crc=5d YES\n6f fe 4b 46 5f ff 01 10 5d t=-25125\n",
+			value:   -25125,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18S20 positive",
+			family:  "10",
+			content: "32 00 4b 46 ff ff 0c 10 1c : crc=1c YES\n32 00 4b 46 ff ff 0c 10 1c t=25000\n",
+			value:   25000,
+			crcOk:   true,
+		},
+		{
+			name:    "DS18S20 negative",
+			family:  "10",
+			content: "eb ff 4b 46 ff ff 04 10 a9 : crc=a9 YES\neb ff 4b 46 ff ff 04 10 a9 t=-10500\n",
+			value:   -10500,
+			crcOk:   true,
+		},
+		{
+			name:    "DS1822",
+			family:  "22",
+			content: "a2 00 4b 46 7f ff 0e 10 d8 : crc=d8 YES\na2 00 4b 46 7f ff 0e 10 d8 t=10125\n",
+			value:   10125,
+			crcOk:   true,
+		},
+		{
+			name:    "DS1825",
+			family:  "3b",
+			content: "f8 ff 4b 46 7f ff 08 10 3a : crc=3a YES\nf8 ff 4b 46 7f ff 08 10 3a t=-500\n",
+			value:   -500,
+			crcOk:   true,
+		},
+		{
+			name:    "DS28EA00",
+			family:  "42",
+			content: "91 01 ff ff 7f ff 0f 10 e6 : crc=e6 YES\n91 01 ff ff 7f ff 0f 10 e6 t=25062\n",
+			value:   25062,
+			crcOk:   true,
+		},
+		{
+			name:    "crc error",
+			family:  "28",
+			content: "72 01 4b 46 7f ff 0e 10 50 : crc=57 NO\n72 01 4b 46 7f ff 0e 10 50 t=23125\n",
+			value:   23125,
+			crcOk:   false,
+		},
+		{
+			name:    "unknown family uses t= value",
+			family:  "ff",
+			content: "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=-1250\n",
+			value:   -1250,
+			crcOk:   true,
+		},
+		{
+			name:    "scratchpad missing uses t= value",
+			family:  "28",
+			content: "72 01 4b : crc=57 YES\n72 01 4b t=23125\n",
+			value:   23125,
+			crcOk:   true,
+		},
+	}
+
+	for _, test := range tests {
+		readout, err := parseW1Slave(test.family, []byte(test.content))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if readout.Value != test.value {
+			t.Errorf("%s: expected value %d, got %d", test.name, test.value, readout.Value)
+		}
+		if readout.CrcOk != test.crcOk {
+			t.Errorf("%s: expected crc ok %v, got %v", test.name, test.crcOk, readout.CrcOk)
+		}
+	}
+}
+
+func TestParseW1SlaveErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty file", ""},
+		{"truncated after first line", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n"},
+		{"truncated second line", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f"},
+		{"missing t=", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57\n"},
+		{"empty t= value", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=\n"},
+		{"bad t= value", "72 01 4b 46 7f ff 0e 10 57 : crc=57 YES\n72 01 4b 46 7f ff 0e 10 57 t=23.1\n"},
+		{"missing crc", "72 01 4b 46 7f ff 0e 10 57\n72 01 4b 46 7f ff 0e 10 57 t=23125\n"},
+	}
+
+	for _, test := range tests {
+		_, err := parseW1Slave("28", []byte(test.content))
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}